@@ -0,0 +1,634 @@
+/*
+Package anthropic sends gogpt queries to Anthropic's Messages API, described here:
+https://docs.anthropic.com/en/api/messages
+
+A Provider is a gogpt.Completer, so chats, summarizers and the other helpers run against Claude
+models unchanged once it is plugged in:
+
+	provider := anthropic.New(os.Getenv("ANTHROPIC_API_KEY"))
+
+	chat := gogpt.NewGoGPTChat("").SetCompleter(provider)
+	chat.Query.Model = anthropic.MODEL_CLAUDE_3_5_SONNET
+	resp, err := chat.AddMessage(gogpt.ROLE_USER, "", "Can pigs fly?").Generate()
+
+Each query is translated on the way out. System messages, wherever they are in the history, are
+joined into the system prompt. Consecutive messages from the same side are merged so user and
+assistant turns alternate, tool and function results become tool_result blocks, and functions
+and tools become Messages API tools. max_tokens is required, so queries without one get the
+provider's MaxTokens. Replies come back as a GoGPTResponse with tool_use blocks as tool calls (or
+a function call if the query used the legacy functions), the stop reason mapped to FinishReason,
+and cache reads and writes counted as prompt tokens.
+
+The Messages API has no equivalent for N, LogitBias, PresencePenalty or ResponseFormat, and a
+query setting any of them is refused. Streaming is not supported. Claude models are registered
+with gogpt on import; their token counts are estimates.
+*/
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dratner/gogpt"
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	API_ENDPOINT            = "https://api.anthropic.com/v1/messages"
+	API_VERSION             = "2023-06-01"
+	DEFAULT_MAX_TOKENS      = 1024
+	MODEL_CLAUDE_3_5_SONNET = "claude-3-5-sonnet-latest"
+	MODEL_CLAUDE_3_5_HAIKU  = "claude-3-5-haiku-latest"
+	MODEL_CLAUDE_3_OPUS     = "claude-3-opus-latest"
+	BLOCK_TEXT              = "text"
+	BLOCK_TOOL_USE          = "tool_use"
+	BLOCK_TOOL_RESULT       = "tool_result"
+	STOP_END_TURN           = "end_turn"
+	STOP_MAX_TOKENS         = "max_tokens"
+	STOP_SEQUENCE           = "stop_sequence"
+	STOP_TOOL_USE           = "tool_use"
+	STOP_REFUSAL            = "refusal"
+	STATUS_OVERLOADED       = 529
+	// The Messages API needs a user turn first, so a history starting with the assistant gets this.
+	CONTINUE_PROMPT = "Continue."
+)
+
+func init() {
+
+	builtin := []gogpt.ModelInfo{
+		{Name: "claude-3-5-sonnet", ContextWindow: 200000, MaxOutputTokens: 8192, PromptPrice: 3, CompletionPrice: 15, Functions: true, Vision: true},
+		{Name: "claude-3-5-haiku", ContextWindow: 200000, MaxOutputTokens: 8192, PromptPrice: 0.8, CompletionPrice: 4, Functions: true},
+		{Name: "claude-3-opus", ContextWindow: 200000, MaxOutputTokens: 4096, PromptPrice: 15, CompletionPrice: 75, Functions: true, Vision: true},
+	}
+
+	for _, m := range builtin {
+		gogpt.RegisterModel(m)
+	}
+}
+
+/*
+	Messages API types
+*/
+
+// A Block is one piece of a message's content: text, a tool call, or a tool's result.
+type Block struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Id        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseId string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type Message struct {
+	Role    string  `json:"role"`
+	Content []Block `json:"content"`
+}
+
+type Tool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type Metadata struct {
+	UserId string `json:"user_id,omitempty"`
+}
+
+type Request struct {
+	Model         string      `json:"model"`
+	MaxTokens     int         `json:"max_tokens"`
+	System        string      `json:"system,omitempty"`
+	Messages      []Message   `json:"messages"`
+	Tools         []Tool      `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice `json:"tool_choice,omitempty"`
+	Temperature   float32     `json:"temperature,omitempty"`
+	TopP          float32     `json:"top_p,omitempty"`
+	StopSequences []string    `json:"stop_sequences,omitempty"`
+	Metadata      *Metadata   `json:"metadata,omitempty"`
+}
+
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type Response struct {
+	Error      *Error  `json:"error,omitempty"`
+	Id         string  `json:"id"`
+	Type       string  `json:"type"`
+	Role       string  `json:"role"`
+	Model      string  `json:"model"`
+	Content    []Block `json:"content"`
+	StopReason string  `json:"stop_reason"`
+	Usage      Usage   `json:"usage"`
+}
+
+/*
+	Only Key is required. Retry defaults to DefaultRetryPolicy, and HTTPClient to resty's own
+	client. Each attempt is bounded by the query's Timeout.
+*/
+
+type Provider struct {
+	Key        string
+	Endpoint   string
+	Version    string
+	MaxTokens  int
+	Retry      *gogpt.RetryPolicy
+	HTTPClient *http.Client
+	once       sync.Once
+	resty      *resty.Client
+}
+
+func New(key string) *Provider {
+	return &Provider{
+		Key:       key,
+		Endpoint:  API_ENDPOINT,
+		Version:   API_VERSION,
+		MaxTokens: DEFAULT_MAX_TOKENS,
+	}
+}
+
+// DefaultRetryPolicy is gogpt's default policy, also retrying when the API is overloaded.
+func DefaultRetryPolicy() *gogpt.RetryPolicy {
+
+	p := gogpt.DefaultRetryPolicy()
+	p.RetryStatuses = append(p.RetryStatuses, STATUS_OVERLOADED)
+	p.RetryErrorTypes = append(p.RetryErrorTypes, "overloaded_error", "api_error", "rate_limit_error")
+
+	return p
+}
+
+// Complete sends q to the Messages API and returns the reply as a chat completion.
+func (p *Provider) Complete(ctx context.Context, q *gogpt.GoGPTQuery) (*gogpt.GoGPTResponse, error) {
+
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	req, err := p.Translate(q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Accountant != nil {
+
+		prompt, err := gogpt.CountQueryTokens(q)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := q.Accountant.Allow(q.Model, gogpt.GoGPTUsage{PromptTokens: prompt, CompletionTokens: req.MaxTokens}); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := p.send(ctx, req, q.Timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := convert(resp, legacy(q))
+
+	if q.Accountant != nil {
+		q.Accountant.Record(q.Model, q.User, q.Tags, out.Usage)
+	}
+
+	return out, nil
+}
+
+// Translate builds the Messages API request for a query.
+func (p *Provider) Translate(q *gogpt.GoGPTQuery) (*Request, error) {
+
+	var unsupported []string
+
+	if q.N > 1 {
+		unsupported = append(unsupported, "n")
+	}
+
+	if len(q.LogitBias) > 0 {
+		unsupported = append(unsupported, "logit_bias")
+	}
+
+	if q.PresencePenalty != 0 {
+		unsupported = append(unsupported, "presence_penalty")
+	}
+
+	if q.ResponseFormat != nil {
+		unsupported = append(unsupported, "response_format")
+	}
+
+	if len(unsupported) > 0 {
+		return nil, fmt.Errorf("not supported by the messages api: %s", strings.Join(unsupported, ", "))
+	}
+
+	req := &Request{
+		Model:     q.Model,
+		MaxTokens: q.MaxTokens,
+		TopP:      q.TopP,
+	}
+
+	if req.MaxTokens <= 0 {
+		req.MaxTokens = p.MaxTokens
+	}
+
+	if req.MaxTokens <= 0 {
+		req.MaxTokens = DEFAULT_MAX_TOKENS
+	}
+
+	// Temperature runs from 0 to 1 rather than 0 to 2.
+	req.Temperature = q.Temperature
+
+	if req.Temperature > 1 {
+		req.Temperature = 1
+	}
+
+	if q.Stop != "" {
+		req.StopSequences = []string{q.Stop}
+	}
+
+	if q.User != "" {
+		req.Metadata = &Metadata{UserId: q.User}
+	}
+
+	var system []string
+
+	// Legacy function calls have no ids, so each is given one for its result to refer to.
+	function := ""
+
+	for i, msg := range q.Messages {
+
+		switch msg.Role {
+		case gogpt.ROLE_SYSTEM:
+			if strings.TrimSpace(msg.Content) != "" {
+				system = append(system, msg.Content)
+			}
+		case gogpt.ROLE_USER:
+			req.add(gogpt.ROLE_USER, text(msg.Content)...)
+		case gogpt.ROLE_ASSISTANT:
+
+			blocks := text(msg.Content)
+
+			for _, call := range msg.ToolCalls {
+
+				input, err := arguments(call.Function)
+
+				if err != nil {
+					return nil, err
+				}
+
+				blocks = append(blocks, Block{Type: BLOCK_TOOL_USE, Id: call.Id, Name: call.Function.Name, Input: input})
+			}
+
+			if msg.FunctionCall != nil {
+
+				input, err := arguments(*msg.FunctionCall)
+
+				if err != nil {
+					return nil, err
+				}
+
+				function = fmt.Sprintf("function_%d", i)
+				blocks = append(blocks, Block{Type: BLOCK_TOOL_USE, Id: function, Name: msg.FunctionCall.Name, Input: input})
+			}
+
+			req.add(gogpt.ROLE_ASSISTANT, blocks...)
+		case gogpt.ROLE_TOOL:
+			req.add(gogpt.ROLE_USER, Block{Type: BLOCK_TOOL_RESULT, ToolUseId: msg.ToolCallId, Content: msg.Content})
+		case gogpt.ROLE_FUNCTION:
+
+			if function == "" {
+				return nil, fmt.Errorf("message %d is a function result without a function call", i)
+			}
+
+			req.add(gogpt.ROLE_USER, Block{Type: BLOCK_TOOL_RESULT, ToolUseId: function, Content: msg.Content})
+			function = ""
+		default:
+			return nil, fmt.Errorf("message %d has unknown role %q", i, msg.Role)
+		}
+	}
+
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("no user or assistant messages")
+	}
+
+	if req.Messages[0].Role != gogpt.ROLE_USER {
+		req.Messages = append([]Message{{Role: gogpt.ROLE_USER, Content: text(CONTINUE_PROMPT)}}, req.Messages...)
+	}
+
+	req.System = strings.Join(system, "\n\n")
+
+	if err := req.setTools(q); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// add appends content to the last message if it is from the same side, keeping turns alternating.
+func (r *Request) add(role string, blocks ...Block) {
+
+	if len(blocks) == 0 {
+		return
+	}
+
+	if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == role {
+		r.Messages[n-1].Content = append(r.Messages[n-1].Content, blocks...)
+		return
+	}
+
+	r.Messages = append(r.Messages, Message{Role: role, Content: blocks})
+}
+
+// text is a text block, or nothing for empty content, which the API refuses.
+func text(content string) []Block {
+
+	if strings.TrimSpace(content) == "" {
+		return nil
+	}
+
+	return []Block{{Type: BLOCK_TEXT, Text: content}}
+}
+
+func arguments(call gogpt.GoGPTFunctionCall) (json.RawMessage, error) {
+
+	if strings.TrimSpace(call.Arguments) == "" {
+		return json.RawMessage("{}"), nil
+	}
+
+	if !json.Valid([]byte(call.Arguments)) {
+		return nil, fmt.Errorf("arguments to %s are not valid json", call.Name)
+	}
+
+	return json.RawMessage(call.Arguments), nil
+}
+
+func (r *Request) setTools(q *gogpt.GoGPTQuery) error {
+
+	var functions []gogpt.GoGPTFunction
+
+	for _, t := range q.Tools {
+		if t.Type == gogpt.TOOL_TYPE_FUNCTION {
+			functions = append(functions, t.Function)
+		}
+	}
+
+	functions = append(functions, q.Functions...)
+
+	if len(functions) == 0 {
+		return nil
+	}
+
+	choice := q.ToolChoice
+
+	if choice == nil && q.FunctionCall != "" {
+		choice = q.FunctionCall
+		if q.FunctionCall != gogpt.TOOL_CHOICE_AUTO && q.FunctionCall != gogpt.TOOL_CHOICE_NONE {
+			choice = gogpt.GoGPTToolChoice{Type: gogpt.TOOL_TYPE_FUNCTION, Function: gogpt.GoGPTToolChoiceFunction{Name: q.FunctionCall}}
+		}
+	}
+
+	switch c := choice.(type) {
+	case nil:
+	case string:
+		switch c {
+		case gogpt.TOOL_CHOICE_AUTO:
+			r.ToolChoice = &ToolChoice{Type: "auto"}
+		case gogpt.TOOL_CHOICE_REQUIRED:
+			r.ToolChoice = &ToolChoice{Type: "any"}
+		case gogpt.TOOL_CHOICE_NONE:
+			// The tools stay defined, since a history with tool_use blocks is refused without them.
+			r.ToolChoice = &ToolChoice{Type: "none"}
+		default:
+			return fmt.Errorf("unknown tool choice %q", c)
+		}
+	case gogpt.GoGPTToolChoice:
+		r.ToolChoice = &ToolChoice{Type: "tool", Name: c.Function.Name}
+	case *gogpt.GoGPTToolChoice:
+		r.ToolChoice = &ToolChoice{Type: "tool", Name: c.Function.Name}
+	default:
+		return fmt.Errorf("unsupported tool choice %v", choice)
+	}
+
+	if q.ParallelToolCalls != nil && !*q.ParallelToolCalls && (r.ToolChoice == nil || r.ToolChoice.Type != "none") {
+		if r.ToolChoice == nil {
+			r.ToolChoice = &ToolChoice{Type: "auto"}
+		}
+		r.ToolChoice.DisableParallelToolUse = true
+	}
+
+	for _, f := range functions {
+
+		var schema interface{} = map[string]interface{}{"type": "object"}
+
+		if f.Parameters != nil {
+			schema = f.Parameters
+		}
+
+		r.Tools = append(r.Tools, Tool{Name: f.Name, Description: f.Description, InputSchema: schema})
+	}
+
+	return nil
+}
+
+// legacy reports whether a query uses functions rather than tools, so calls come back as a FunctionCall.
+func legacy(q *gogpt.GoGPTQuery) bool {
+	return len(q.Functions) > 0 && len(q.Tools) == 0
+}
+
+func convert(r *Response, legacy bool) *gogpt.GoGPTResponse {
+
+	msg := gogpt.GoGPTMessage{Role: gogpt.ROLE_ASSISTANT}
+
+	for _, b := range r.Content {
+
+		switch b.Type {
+		case BLOCK_TEXT:
+			msg.Content += b.Text
+		case BLOCK_TOOL_USE:
+
+			call := gogpt.GoGPTFunctionCall{Name: b.Name, Arguments: string(b.Input)}
+
+			if call.Arguments == "" {
+				call.Arguments = "{}"
+			}
+
+			if legacy {
+				if msg.FunctionCall == nil {
+					msg.FunctionCall = &call
+				}
+				continue
+			}
+
+			msg.ToolCalls = append(msg.ToolCalls, gogpt.GoGPTToolCall{Id: b.Id, Type: gogpt.TOOL_TYPE_FUNCTION, Function: call})
+		}
+	}
+
+	prompt := r.Usage.InputTokens + r.Usage.CacheCreationInputTokens + r.Usage.CacheReadInputTokens
+
+	return &gogpt.GoGPTResponse{
+		Id:      r.Id,
+		Object:  "chat.completion",
+		Created: int32(time.Now().Unix()),
+		Model:   r.Model,
+		Choices: []gogpt.GoGPTChoice{{Index: 0, Message: msg, FinishReason: finishReason(r.StopReason, legacy)}},
+		Usage: gogpt.GoGPTUsage{
+			PromptTokens:     prompt,
+			CompletionTokens: r.Usage.OutputTokens,
+			TotalTokens:      prompt + r.Usage.OutputTokens,
+		},
+	}
+}
+
+// finishReason maps a stop reason onto the chat completions equivalent.
+func finishReason(stop string, legacy bool) string {
+
+	switch stop {
+	case STOP_END_TURN, STOP_SEQUENCE:
+		return "stop"
+	case STOP_MAX_TOKENS:
+		return "length"
+	case STOP_TOOL_USE:
+		if legacy {
+			return "function_call"
+		}
+		return "tool_calls"
+	case STOP_REFUSAL:
+		return "content_filter"
+	}
+
+	return stop
+}
+
+/*
+	Transport
+*/
+
+func (p *Provider) client() *resty.Client {
+
+	p.once.Do(func() {
+		if p.HTTPClient != nil {
+			// resty fills in a missing transport on the client it is given, so give it a copy.
+			hc := *p.HTTPClient
+			p.resty = resty.NewWithClient(&hc)
+		} else {
+			p.resty = resty.New()
+		}
+	})
+
+	return p.resty
+}
+
+func (p *Provider) send(ctx context.Context, body *Request, timeout time.Duration) (*Response, error) {
+
+	endpoint := p.Endpoint
+
+	if endpoint == "" {
+		endpoint = API_ENDPOINT
+	}
+
+	version := p.Version
+
+	if version == "" {
+		version = API_VERSION
+	}
+
+	retry := p.Retry
+
+	if retry == nil {
+		retry = DefaultRetryPolicy()
+	}
+
+	req := p.client().R().
+		SetHeader("x-api-key", p.Key).
+		SetHeader("anthropic-version", version).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+
+	resp, err := retry.Do(ctx, func() (*resty.Response, error) {
+		actx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+		return req.SetContext(actx).Post(endpoint)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := new(Response)
+
+	if err := json.Unmarshal(resp.Body(), out); err != nil {
+		if resp.IsError() {
+			return nil, apiError(resp, nil)
+		}
+		return nil, err
+	}
+
+	if out.Error != nil || resp.IsError() {
+		return nil, apiError(resp, out.Error)
+	}
+
+	return out, nil
+}
+
+// withTimeout bounds a single attempt by the query's Timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// apiError reports a failure as a *gogpt.APIError, with codes chosen so gogpt's sentinel errors match.
+func apiError(resp *resty.Response, body *Error) *gogpt.APIError {
+
+	e := &gogpt.APIError{
+		StatusCode: resp.StatusCode(),
+		RequestId:  resp.Header().Get("request-id"),
+	}
+
+	if body == nil {
+		e.Message = strings.TrimSpace(string(resp.Body()))
+		return e
+	}
+
+	e.Message = body.Message
+	e.Type = body.Type
+
+	switch {
+	case body.Type == "rate_limit_error":
+		e.Code = "rate_limit_exceeded"
+	case body.Type == "authentication_error":
+		e.Code = "invalid_api_key"
+	case strings.Contains(body.Message, "prompt is too long"):
+		e.Code = "context_length_exceeded"
+	case strings.Contains(body.Message, "credit balance"):
+		e.Code = "insufficient_quota"
+	}
+
+	return e
+}
@@ -0,0 +1,314 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dratner/gogpt"
+	"github.com/dratner/gogpt/gogpttest"
+)
+
+func TestMain(m *testing.M) {
+
+	gogpttest.UseOfflineTokenizer()
+
+	os.Exit(m.Run())
+}
+
+type testWeather struct {
+	City string `json:"city"`
+}
+
+// testServer answers with reply, or a canned text reply if it is empty, and keeps each request body.
+func testServer(t *testing.T, requests *[]Request, reply string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != API_VERSION {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		var req Request
+
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("could not decode request: %v", err)
+		}
+
+		*requests = append(*requests, req)
+
+		if reply == "" {
+			reply = `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-haiku-20241022","content":[{"type":"text","text":"No."}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2}}`
+		}
+
+		fmt.Fprint(w, reply)
+	}))
+}
+
+func TestTranslate(t *testing.T) {
+
+	q := gogpt.NewGoGPTQuery("")
+	q.Model = MODEL_CLAUDE_3_5_HAIKU
+	q.MaxTokens = 0
+	q.Temperature = 1.5
+	q.Stop = "END"
+	q.User = "user-1"
+	q.ToolChoice = gogpt.TOOL_CHOICE_REQUIRED
+
+	if _, err := q.AddTool("get_weather", "Get the weather", testWeather{}); err != nil {
+		t.Fatalf("error adding tool: %v", err)
+	}
+
+	q.AddMessage(gogpt.ROLE_SYSTEM, "", "You are a farmer.")
+	q.AddMessage(gogpt.ROLE_USER, "", "What's the weather in Paris and Rome?")
+	q.Messages = append(q.Messages, gogpt.GoGPTMessage{Role: gogpt.ROLE_ASSISTANT, ToolCalls: []gogpt.GoGPTToolCall{
+		{Id: "call_1", Type: gogpt.TOOL_TYPE_FUNCTION, Function: gogpt.GoGPTFunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		{Id: "call_2", Type: gogpt.TOOL_TYPE_FUNCTION, Function: gogpt.GoGPTFunctionCall{Name: "get_weather", Arguments: `{"city":"Rome"}`}},
+	}})
+	q.AddToolResult("call_1", "Sunny")
+	q.AddToolResult("call_2", "Rainy")
+	q.AddMessage(gogpt.ROLE_SYSTEM, "", "Summary: the user farms pigs.")
+	q.AddMessage(gogpt.ROLE_USER, "", "Thanks.")
+	q.AddMessage(gogpt.ROLE_USER, "", "Can pigs fly?")
+
+	req, err := New("test-key").Translate(q)
+
+	if err != nil {
+		t.Fatalf("error translating: %v", err)
+	}
+
+	if req.System != "You are a farmer.\n\nSummary: the user farms pigs." || req.MaxTokens != DEFAULT_MAX_TOKENS || req.Temperature != 1 {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	if len(req.StopSequences) != 1 || req.Metadata.UserId != "user-1" || req.ToolChoice.Type != "any" {
+		t.Errorf("unexpected options: %+v", req)
+	}
+
+	if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" || req.Tools[0].InputSchema == nil {
+		t.Errorf("unexpected tools: %+v", req.Tools)
+	}
+
+	msgs := req.Messages
+
+	if len(msgs) != 3 || msgs[0].Role != gogpt.ROLE_USER || msgs[1].Role != gogpt.ROLE_ASSISTANT || msgs[2].Role != gogpt.ROLE_USER {
+		t.Fatalf("expected alternating turns, got %+v", msgs)
+	}
+
+	if len(msgs[1].Content) != 2 || msgs[1].Content[1].Type != BLOCK_TOOL_USE || string(msgs[1].Content[1].Input) != `{"city":"Rome"}` {
+		t.Errorf("unexpected tool calls: %+v", msgs[1])
+	}
+
+	results := msgs[2].Content
+
+	if len(results) != 4 || results[0].ToolUseId != "call_1" || results[1].Content != "Rainy" || results[3].Text != "Can pigs fly?" {
+		t.Errorf("unexpected tool results: %+v", results)
+	}
+
+	// Legacy functions get ids so their results can refer to them.
+	q = gogpt.NewGoGPTQuery("")
+	q.Model = MODEL_CLAUDE_3_5_HAIKU
+	q.FunctionCall = "none"
+
+	if _, err := q.AddFunction("get_weather", "Get the weather", testWeather{}); err != nil {
+		t.Fatalf("error adding function: %v", err)
+	}
+
+	q.Messages = []gogpt.GoGPTMessage{
+		{Role: gogpt.ROLE_ASSISTANT, Content: "Hello."},
+		{Role: gogpt.ROLE_USER, Content: "Weather?"},
+		{Role: gogpt.ROLE_ASSISTANT, FunctionCall: &gogpt.GoGPTFunctionCall{Name: "get_weather"}},
+		{Role: gogpt.ROLE_FUNCTION, Name: "get_weather", Content: "Sunny"},
+	}
+
+	req, err = New("test-key").Translate(q)
+
+	if err != nil {
+		t.Fatalf("error translating: %v", err)
+	}
+
+	msgs = req.Messages
+
+	if len(msgs) != 5 || msgs[0].Content[0].Text != CONTINUE_PROMPT || msgs[3].Content[0].Id != "function_2" || msgs[4].Content[0].ToolUseId != "function_2" || string(msgs[3].Content[0].Input) != "{}" {
+		t.Errorf("unexpected legacy translation: %+v", msgs)
+	}
+
+	// The history has tool_use blocks, so the tools stay defined and calling them is forbidden instead.
+	if len(req.Tools) != 1 || req.ToolChoice == nil || req.ToolChoice.Type != "none" {
+		t.Errorf("expected tools with a none choice when function calls are disabled, got %+v %+v", req.Tools, req.ToolChoice)
+	}
+
+	q.N = 2
+	q.PresencePenalty = 1
+
+	if _, err := New("test-key").Translate(q); err == nil || err.Error() != "not supported by the messages api: n, presence_penalty" {
+		t.Errorf("expected unsupported options to be refused, got %v", err)
+	}
+}
+
+func TestComplete(t *testing.T) {
+
+	var requests []Request
+
+	server := testServer(t, &requests, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-haiku-20241022",
+		"content":[{"type":"text","text":"Let me check."},{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}],
+		"stop_reason":"tool_use","usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":20}}`)
+	defer server.Close()
+
+	provider := New("test-key")
+	provider.Endpoint = server.URL
+
+	acct := gogpt.NewUsageAccountant(0)
+
+	q := gogpt.NewGoGPTQuery("")
+	q.Model = MODEL_CLAUDE_3_5_HAIKU
+	q.Accountant = acct
+	q.AddMessage(gogpt.ROLE_USER, "", "What's the weather in Paris?")
+
+	if _, err := q.AddTool("get_weather", "Get the weather", testWeather{}); err != nil {
+		t.Fatalf("error adding tool: %v", err)
+	}
+
+	resp, err := provider.Complete(context.Background(), q)
+
+	if err != nil {
+		t.Fatalf("error completing: %v", err)
+	}
+
+	choice := resp.Choices[0]
+
+	if choice.Message.Content != "Let me check." || choice.FinishReason != "tool_calls" || len(choice.Message.ToolCalls) != 1 {
+		t.Fatalf("unexpected choice: %+v", choice)
+	}
+
+	if call := choice.Message.ToolCalls[0]; call.Id != "toolu_1" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool call: %+v", call)
+	}
+
+	if resp.Usage.PromptTokens != 30 || resp.Usage.CompletionTokens != 5 || resp.Usage.TotalTokens != 35 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+
+	if total := acct.Total(); total.Requests != 1 || total.Cost == 0 {
+		t.Errorf("expected the usage to be priced, got %+v", total)
+	}
+
+	if len(requests) != 1 || requests[0].MaxTokens != q.MaxTokens || requests[0].Model != MODEL_CLAUDE_3_5_HAIKU {
+		t.Errorf("unexpected request: %+v", requests)
+	}
+
+	for stop, finish := range map[string]string{STOP_END_TURN: "stop", STOP_MAX_TOKENS: "length", STOP_REFUSAL: "content_filter"} {
+		if got := finishReason(stop, false); got != finish {
+			t.Errorf("expected %s for %s, got %s", finish, stop, got)
+		}
+	}
+}
+
+func TestChat(t *testing.T) {
+
+	var requests []Request
+
+	server := testServer(t, &requests, "")
+	defer server.Close()
+
+	provider := New("test-key")
+	provider.Endpoint = server.URL
+
+	chat := gogpt.NewGoGPTChat("").SetCompleter(provider)
+	chat.Query.Model = MODEL_CLAUDE_3_5_HAIKU
+	chat.Query.AddMessage(gogpt.ROLE_SYSTEM, "", "You are a farmer.")
+
+	for _, question := range []string{"Can pigs fly?", "Can cows fly?"} {
+		if _, err := chat.AddMessage(gogpt.ROLE_USER, "", question).Generate(); err != nil {
+			t.Fatalf("error generating: %v", err)
+		}
+	}
+
+	last := requests[len(requests)-1]
+
+	if len(requests) != 2 || last.System != "You are a farmer." || len(last.Messages) != 3 || last.Messages[1].Content[0].Text != "No." {
+		t.Errorf("unexpected requests: %+v", requests)
+	}
+
+	if n := len(chat.Query.Messages); n != 5 || chat.Query.Messages[n-1].Content != "No." {
+		t.Errorf("unexpected history: %+v", chat.Query.Messages)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+
+	var requests []Request
+
+	server := testServer(t, &requests, "")
+	defer server.Close()
+
+	hc := &http.Client{}
+
+	provider := New("test-key")
+	provider.Endpoint = server.URL
+	provider.HTTPClient = hc
+
+	q := gogpt.NewGoGPTQuery("")
+	q.Model = MODEL_CLAUDE_3_5_HAIKU
+	q.AddMessage(gogpt.ROLE_USER, "", "Can pigs fly?")
+
+	if _, err := provider.Complete(context.Background(), q); err != nil || len(requests) != 1 {
+		t.Fatalf("error completing: %v", err)
+	}
+
+	if hc.Transport != nil {
+		t.Errorf("the caller's client was changed: %T", hc.Transport)
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		calls++
+
+		switch calls {
+		case 1:
+			w.Header().Set("retry-after", "0")
+			w.WriteHeader(STATUS_OVERLOADED)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+		case 2:
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"No."}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`)
+		case 3:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 250000 tokens > 200000 maximum"}}`)
+		}
+	}))
+	defer server.Close()
+
+	provider := New("test-key")
+	provider.Endpoint = server.URL
+
+	q := gogpt.NewGoGPTQuery("")
+	q.Model = MODEL_CLAUDE_3_5_HAIKU
+	q.AddMessage(gogpt.ROLE_USER, "", "Can pigs fly?")
+
+	if resp, err := provider.Complete(context.Background(), q); err != nil || calls != 2 || resp.Choices[0].Message.Content != "No." {
+		t.Fatalf("expected the overloaded request to be retried, got %v after %d calls", err, calls)
+	}
+
+	if _, err := provider.Complete(context.Background(), q); !errors.Is(err, gogpt.ErrInvalidAPIKey) {
+		t.Errorf("expected an invalid key error, got %v", err)
+	}
+
+	if _, err := provider.Complete(context.Background(), q); !errors.Is(err, gogpt.ErrContextLengthExceeded) {
+		t.Errorf("expected a context length error, got %v", err)
+	}
+}
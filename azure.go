@@ -0,0 +1,67 @@
+package gogpt
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+/*
+	Azure OpenAI serves the same API from a URL per deployment, described here:
+	https://learn.microsoft.com/en-us/azure/ai-services/openai/reference
+
+	https://{resource}.openai.azure.com/openai/deployments/{deployment}/chat/completions?api-version={version}
+
+	Requests are authenticated with an api-key header rather than a bearer token. Configure a client
+	for Azure and use its queries, chats and embeddings as usual:
+
+	client := NewClient(key, WithAzure(AzureConfig{
+		Endpoint:    "https://my-resource.openai.azure.com",
+		Deployments: map[string]string{MODEL_4o: "gpt-4o-prod"},
+	}))
+
+	The deployment is picked from the query's Model when the request is sent, so changing the model
+	of a query, or summarizing with a cheaper one, reaches the right deployment. Models without an
+	entry in Deployments go to a deployment of the same name. Queries created by an Azure client
+	have an empty Endpoint; setting one sends there instead, still with the api-key header.
+*/
+
+const (
+	AZURE_API_VERSION = "2024-10-21"
+)
+
+type AzureConfig struct {
+	Endpoint    string            // the resource URL, https://{resource}.openai.azure.com
+	APIVersion  string            // defaults to AZURE_API_VERSION
+	Deployments map[string]string // model name to deployment name
+}
+
+// WithAzure sends the client's requests to Azure OpenAI deployments.
+func WithAzure(cfg AzureConfig) ClientOption {
+	return func(c *Client) {
+		cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+		c.azure = &cfg
+	}
+}
+
+// Deployment returns the deployment that serves model.
+func (a *AzureConfig) Deployment(model string) string {
+
+	if d, ok := a.Deployments[model]; ok && d != "" {
+		return d
+	}
+
+	return model
+}
+
+// url builds the address of an operation, such as CHAT_COMPLETIONS_PATH, on the deployment for model.
+func (a *AzureConfig) url(path string, model string) string {
+
+	version := a.APIVersion
+
+	if version == "" {
+		version = AZURE_API_VERSION
+	}
+
+	return fmt.Sprintf("%s/openai/deployments/%s%s?api-version=%s", a.Endpoint, url.PathEscape(a.Deployment(model)), path, url.QueryEscape(version))
+}
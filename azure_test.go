@@ -0,0 +1,124 @@
+package gogpt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAzure(t *testing.T) {
+
+	var requests []*http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		requests = append(requests, r)
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, EMBEDDINGS_PATH):
+			fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.5,0.5]}],"usage":{"prompt_tokens":2,"total_tokens":2}}`)
+		case r.Header.Get("Accept") == "text/event-stream":
+			fmt.Fprint(w, "data: {\"choices\":[],\"prompt_filter_results\":[]}\n\n")
+			fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"No.\"}}]}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		default:
+			fmt.Fprint(w, testReply)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient("azure-key", WithOrganization("org-123"), WithAzure(AzureConfig{
+		Endpoint:    server.URL + "/",
+		Deployments: map[string]string{MODEL_4o: "gpt-4o-prod"},
+	}))
+
+	q := client.NewQuery()
+	q.Model = MODEL_4o
+	q.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if q.Endpoint != "" {
+		t.Errorf("expected an empty endpoint, got %s", q.Endpoint)
+	}
+
+	if _, err := q.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	// A model without a deployment mapping uses its own name, and chats work unchanged.
+	chat := client.NewChat()
+	chat.Query.Model = MODEL_4o_MINI
+	chat.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating chat: %v", err)
+	}
+
+	stream, err := q.GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error streaming: %v", err)
+	}
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err != io.EOF {
+				t.Errorf("error receiving: %v", err)
+			}
+			break
+		}
+	}
+
+	stream.Close()
+
+	if stream.Response().Choices[0].Message.Content != "No." {
+		t.Errorf("unexpected stream response: %+v", stream.Response())
+	}
+
+	if _, err := client.GetEmbedding(context.Background(), "pigs"); err != nil {
+		t.Fatalf("error embedding: %v", err)
+	}
+
+	paths := []string{
+		"/openai/deployments/gpt-4o-prod/chat/completions",
+		"/openai/deployments/gpt-4o-mini/chat/completions",
+		"/openai/deployments/gpt-4o-prod/chat/completions",
+		"/openai/deployments/text-embedding-ada-002/embeddings",
+	}
+
+	if len(requests) != len(paths) {
+		t.Fatalf("expected %d requests, got %d", len(paths), len(requests))
+	}
+
+	for i, r := range requests {
+
+		if r.URL.Path != paths[i] || r.URL.Query().Get("api-version") != AZURE_API_VERSION {
+			t.Errorf("request %d went to %s", i, r.URL)
+		}
+
+		if r.Header.Get("api-key") != "azure-key" || r.Header.Get("Authorization") != "" || r.Header.Get("OpenAI-Organization") != "" {
+			t.Errorf("request %d has the wrong credentials: %v", i, r.Header)
+		}
+	}
+}
+
+func TestAzureDeployment(t *testing.T) {
+
+	a := AzureConfig{Endpoint: "https://example.openai.azure.com", APIVersion: "2024-06-01", Deployments: map[string]string{MODEL_4o: "my deployment"}}
+
+	if a.Deployment(MODEL_4o) != "my deployment" || a.Deployment(MODEL_4) != MODEL_4 {
+		t.Errorf("unexpected deployments")
+	}
+
+	if u := a.url(CHAT_COMPLETIONS_PATH, MODEL_4o); u != "https://example.openai.azure.com/openai/deployments/my%20deployment/chat/completions?api-version=2024-06-01" {
+		t.Errorf("unexpected url %s", u)
+	}
+
+	// Queries not created by an Azure client keep their endpoints.
+	if q := NewClient("key").NewQuery(); q.Endpoint != API_BASE_URL+CHAT_COMPLETIONS_PATH {
+		t.Errorf("unexpected endpoint %s", q.Endpoint)
+	}
+}
@@ -0,0 +1,273 @@
+package gogpt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+	A Cassette is an http.RoundTripper that records API traffic to a file or replays it, so tests
+	can run offline once they have been recorded against the live API. Plug it into a client:
+
+	cassette, err := NewCassetteRecorder("testdata/cassettes/chat.jsonl", nil)
+	client := NewClient(key, WithTransport(cassette))
+
+	Each line of the file is one JSON encoded request and response. Credentials and account headers
+	are removed before anything is written. A replaying cassette answers requests in the order they
+	were recorded and fails any request whose method, path or body differs from the recording.
+	Streamed responses are recorded whole and replayed in one piece.
+*/
+
+const (
+	CASSETTE_RECORD = "record"
+	CASSETTE_REPLAY = "replay"
+)
+
+// Headers never written to a cassette.
+var scrubbedHeaders = []string{"Authorization", "Api-Key", "OpenAI-Organization", "OpenAI-Project", "Set-Cookie", "Cookie"}
+
+type CassetteRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body"`
+}
+
+type CassetteInteraction struct {
+	Request  CassetteRequest  `json:"request"`
+	Response CassetteResponse `json:"response"`
+}
+
+type Cassette struct {
+	mode         string
+	path         string
+	next         http.RoundTripper
+	mu           sync.Mutex
+	file         *os.File
+	interactions []CassetteInteraction
+	played       int
+}
+
+// NewCassetteRecorder sends requests through next, or http.DefaultTransport if nil, and writes them to a new file at path.
+func NewCassetteRecorder(path string, next http.RoundTripper) (*Cassette, error) {
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cassette{mode: CASSETTE_RECORD, path: path, next: next, file: f}, nil
+}
+
+// NewCassetteReplayer serves the interactions recorded in the file at path without touching the network.
+func NewCassetteReplayer(path string) (*Cassette, error) {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Cassette{mode: CASSETTE_REPLAY, path: path}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var i CassetteInteraction
+
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("could not read cassette %s: %v", path, err)
+		}
+
+		c.interactions = append(c.interactions, i)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cassette) Mode() string {
+	return c.mode
+}
+
+// Remaining returns how many recorded interactions have not been replayed yet.
+func (c *Cassette) Remaining() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.interactions) - c.played
+}
+
+// Close finishes writing a recording. It does nothing when replaying.
+func (c *Cassette) Close() error {
+
+	if c.file == nil {
+		return nil
+	}
+
+	return c.file.Close()
+}
+
+func scrub(h http.Header) http.Header {
+
+	h = h.Clone()
+
+	for _, name := range scrubbedHeaders {
+		h.Del(name)
+	}
+
+	if len(h) == 0 {
+		return nil
+	}
+
+	return h
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	var body []byte
+
+	if req.Body != nil {
+
+		b, err := io.ReadAll(req.Body)
+
+		req.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if c.mode == CASSETTE_REPLAY {
+		return c.replay(req, body)
+	}
+
+	return c.record(req, body)
+}
+
+func (c *Cassette) record(req *http.Request, body []byte) (*http.Response, error) {
+
+	resp, err := c.next.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	line, err := json.Marshal(CassetteInteraction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: scrub(req.Header),
+			Body:    string(body),
+		},
+		Response: CassetteResponse{
+			Status:  resp.StatusCode,
+			Headers: scrub(resp.Header),
+			Body:    string(respBody),
+		},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("could not write cassette %s: %v", c.path, err)
+	}
+
+	return resp, nil
+}
+
+func (c *Cassette) replay(req *http.Request, body []byte) (*http.Response, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.played >= len(c.interactions) {
+		return nil, fmt.Errorf("cassette %s has no interaction for %s %s", c.path, req.Method, req.URL.Path)
+	}
+
+	i := c.interactions[c.played]
+	c.played++
+
+	recorded, err := http.NewRequest(i.Request.Method, i.Request.URL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if recorded.Method != req.Method || recorded.URL.Path != req.URL.Path {
+		return nil, fmt.Errorf("cassette %s expected %s %s as request %d, got %s %s", c.path, recorded.Method, recorded.URL.Path, c.played, req.Method, req.URL.Path)
+	}
+
+	if i.Request.Body != string(body) {
+		return nil, fmt.Errorf("cassette %s request %d has a different body than recorded", c.path, c.played)
+	}
+
+	header := i.Response.Headers.Clone()
+
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Response.Status, http.StatusText(i.Response.Status)),
+		StatusCode:    i.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(i.Response.Body))),
+		ContentLength: int64(len(i.Response.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package gogpt
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Request-Id", fmt.Sprintf("req-%d", calls))
+		fmt.Fprint(w, testReply)
+	}))
+
+	path := filepath.Join(t.TempDir(), "cassettes", "pigs.jsonl")
+
+	recorder, err := NewCassetteRecorder(path, nil)
+
+	if err != nil {
+		t.Fatalf("error creating cassette: %v", err)
+	}
+
+	client := NewClient("secret-key", WithBaseURL(server.URL), WithOrganization("org-secret"), WithTransport(recorder))
+
+	for _, question := range []string{"Can pigs fly?", "Can cows fly?"} {
+		if _, err := client.NewQuery().AddMessage(ROLE_USER, "", question).Generate(); err != nil {
+			t.Fatalf("error recording: %v", err)
+		}
+	}
+
+	recorder.Close()
+	server.Close()
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("error reading cassette: %v", err)
+	}
+
+	if lines := strings.Count(string(data), "\n"); lines != 2 || strings.Contains(string(data), "secret") || !strings.Contains(string(data), "req-2") {
+		t.Errorf("unexpected cassette: %s", data)
+	}
+
+	// Replaying needs neither the server nor the key.
+	replayer, err := NewCassetteReplayer(path)
+
+	if err != nil {
+		t.Fatalf("error loading cassette: %v", err)
+	}
+
+	client = NewClient("test-key", WithBaseURL(server.URL), WithTransport(replayer), WithRetryPolicy(&RetryPolicy{MaxAttempts: 1}))
+
+	resp, err := client.NewQuery().AddMessage(ROLE_USER, "", "Can pigs fly?").Generate()
+
+	if err != nil || resp.Choices[0].Message.Content != "No." {
+		t.Fatalf("unexpected replay: %+v %v", resp, err)
+	}
+
+	if _, err := client.NewQuery().AddMessage(ROLE_USER, "", "Can goats fly?").Generate(); err == nil || !strings.Contains(err.Error(), "different body") {
+		t.Errorf("expected a mismatch error, got %v", err)
+	}
+
+	if _, err := client.NewQuery().AddMessage(ROLE_USER, "", "Can pigs fly?").Generate(); err == nil || replayer.Remaining() != 0 {
+		t.Errorf("expected the cassette to be used up, got %v", err)
+	}
+}
@@ -1,9 +1,8 @@
 package gogpt
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/pkoukk/tiktoken-go"
 )
 
 const (
@@ -24,38 +23,46 @@ const (
 
 	If this number is larger than the number of tokens the model supports, we need to summarize.
 
-	We do this by asking ChatGPT to summarize the message history into a single message of less than MAX_TOKENS tokens.
+	By default we do this by asking ChatGPT to summarize the message history into a single message of less than MAX_TOKENS tokens.
 	A new message history is then generated with the initial prompt, the summary, and the queue of new messages.
+	Set Strategy to any other ContextStrategy to truncate or summarize differently.
 */
 
+// MaxQueryTokens returns the context window of a model from the registry, or DEFAULT_CONTEXT_WINDOW if it is unknown.
 func MaxQueryTokens(model string) int {
-	switch model {
-	case MODEL_4o:
-		return 128000
-	case MODEL_4o_MINI:
-		return 128000
-	case MODEL_4:
-		return 8192
-	case MODEL_35_TURBO:
-		return 16385
-	default:
-		return 16385
+
+	if info, ok := LookupModel(model); ok {
+		return info.ContextWindow
+	}
+
+	return DEFAULT_CONTEXT_WINDOW
+}
+
+// replyTokens is the room to leave for the reply: MaxTokens, or the model's output limit if unset.
+func replyTokens(q *GoGPTQuery) int {
+
+	if q.MaxTokens > 0 {
+		return q.MaxTokens
 	}
+
+	if info, ok := LookupModel(q.Model); ok && info.MaxOutputTokens > 0 {
+		return info.MaxOutputTokens
+	}
+
+	return 0
 }
 
-// This is an estimate of the number of tokens in a string.
+// This is an estimate of the number of tokens in a message's content.
+// It ignores message framing and returns 0 if the encoder can't be loaded; prefer CountMessageTokens.
 func TokenEstimator(msg GoGPTMessage, model string) int {
 
-	tkm, err := tiktoken.EncodingForModel(model)
+	n, err := CountTokens(msg.Content, model)
 
 	if err != nil {
 		return 0
 	}
 
-	// encode
-	token := tkm.Encode(msg.Content, nil, nil)
-
-	return len(token)
+	return n
 }
 
 func NewGoGPTChat(key string) *GoGPTChat {
@@ -68,9 +75,32 @@ type GoGPTChat struct {
 	Query        *GoGPTQuery
 	Summary      string
 	MessageQueue []GoGPTMessage
+	Strategy     ContextStrategy
+	SessionId    string
+	Recorder     ChatRecorder
+	Memory       *ChatMemory
+	Completer    Completer
 	prompt       *GoGPTMessage
 }
 
+// SetCompleter sends the chat's requests, including summaries, through completer instead of straight to the API.
+func (c *GoGPTChat) SetCompleter(completer Completer) *GoGPTChat {
+
+	c.Completer = completer
+
+	return c
+}
+
+// completer returns the Completer for the chat's requests. Without one, the query sends itself.
+func (c *GoGPTChat) completer() Completer {
+
+	if c.Completer != nil {
+		return c.Completer
+	}
+
+	return c.Query
+}
+
 // A convenience function for method chaining
 func (c *GoGPTChat) AddMessage(role string, name string, content string) *GoGPTChat {
 
@@ -85,88 +115,128 @@ func (c *GoGPTChat) AddMessage(role string, name string, content string) *GoGPTC
 	return c
 }
 
-func (g *GoGPTChat) summarize(queueSize int) error {
-
-	// if we don't already know the prompt, find it
-	if g.prompt == nil {
-		for _, msg := range g.Query.Messages {
-			if msg.Role == ROLE_SYSTEM {
-				g.prompt = &msg
-				break
-			}
-		}
-	}
+// A function that encapsulates the query generation method and handles summariation.
+func (g *GoGPTChat) Generate() (*GoGPTResponse, error) {
+	return g.GenerateWithContext(context.Background())
+}
 
-	// if we can't find the prompt, we can't summarize
-	if g.prompt == nil {
-		return fmt.Errorf("no prompt found")
-	}
+// GenerateWithContext is like Generate but ctx also governs any summarization request it makes.
+func (g *GoGPTChat) GenerateWithContext(ctx context.Context) (*GoGPTResponse, error) {
 
-	promptSize := TokenEstimator(*g.prompt, g.Query.Model)
+	// Remember what is new before compacting, which may drop it from the history.
+	var pending []GoGPTMessage
 
-	// make sure the prompt, the summary, the queue, and a return message will fit
-	if (g.Query.MaxTokens + queueSize + promptSize + BUFF_MARGIN) > MaxQueryTokens(g.Query.Model) {
-		return fmt.Errorf("not enough tokens to summarize")
+	if g.Recorder != nil {
+		pending = append(g.unrecorded(), g.MessageQueue...)
 	}
 
-	q := NewGoGPTQuery(g.Query.Key)
+	// everything but the history: the reply, a buffer, the queue, any function definitions, and the reply priming
+	usage, err := g.reserved()
 
-	for _, msg := range g.Query.Messages {
-		if &msg != g.prompt {
-			q.AddMessage(msg.Role, msg.Name, msg.Content)
-		}
+	if err != nil {
+		return nil, err
 	}
 
-	q.AddMessage(ROLE_SYSTEM, "", fmt.Sprintf("Summarize the following chat history. You must use less than %d words.", g.Query.MaxTokens))
-	q.MaxTokens = g.Query.MaxTokens
-
-	resp, err := q.Generate()
+	historySize, err := CountMessageTokens(g.Query.Messages, g.Query.Model)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	g.Query.Messages = []GoGPTMessage{}
-	g.Query.AddMessage(ROLE_SYSTEM, "", g.prompt.Content)
-	g.Query.AddMessage(ROLE_SYSTEM, "", resp.Choices[0].Message.Content)
+	budget := MaxQueryTokens(g.Query.Model) - usage
 
-	return nil
-}
+	if historySize > budget {
 
-// A function that encapsulates the query generation method and handles summariation.
-func (g *GoGPTChat) Generate() (*GoGPTResponse, error) {
+		before := append([]GoGPTMessage{}, g.Query.Messages...)
 
-	var err error
+		if err = g.strategy().Compact(ctx, g, budget); err != nil {
+			return nil, err
+		}
 
-	usage := g.Query.MaxTokens + BUFF_MARGIN // the maximum size of the return message plus a buffer
+		if g.Memory != nil {
+			if err = g.Memory.Remember(ctx, evicted(before, g.Query.Messages)); err != nil {
+				return nil, err
+			}
+		}
 
-	for _, msg := range g.Query.Messages {
-		usage += TokenEstimator(msg, g.Query.Model)
-	}
+		historySize, err = CountMessageTokens(g.Query.Messages, g.Query.Model)
+
+		if err != nil {
+			return nil, err
+		}
 
-	queueSize := 0
-	for _, msg := range g.MessageQueue {
-		queueSize += TokenEstimator(msg, g.Query.Model)
+		if historySize > budget {
+			return nil, fmt.Errorf("history of %d tokens still exceeds the %d available after compacting", historySize, budget)
+		}
 	}
-	usage += queueSize
 
-	if usage > MaxQueryTokens(g.Query.Model) {
-		err = g.summarize(queueSize)
-		if err != nil {
+	var recalled *GoGPTMessage
+
+	if g.Memory != nil {
+		if recalled, err = g.Memory.message(ctx, g.MessageQueue, g.Query.Model); err != nil {
 			return nil, err
 		}
 	}
 
+	history := len(g.Query.Messages)
+
+	// Recalled messages only go into this request, just before the new messages.
+	if recalled != nil {
+		g.Query.Messages = append(g.Query.Messages, *recalled)
+	}
+
 	g.Query.Messages = append(g.Query.Messages, g.MessageQueue...)
 	g.MessageQueue = []GoGPTMessage{}
 
-	resp, err := g.Query.Generate()
+	resp, err := g.completer().Complete(ctx, g.Query)
+
+	if recalled != nil {
+		g.Query.Messages = append(g.Query.Messages[:history], g.Query.Messages[history+1:]...)
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	g.Query.AddMessage(ROLE_ASSISTANT, "", resp.Choices[0].Message.Content)
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("response has no choices")
+	}
+
+	// Keep the whole reply so any tool calls stay paired with their results.
+	reply := resp.Choices[0].Message
+	reply.Role = ROLE_ASSISTANT
+	g.Query.Messages = append(g.Query.Messages, reply)
+
+	// The reply is kept even if it can't be recorded.
+	if g.Recorder != nil {
+		if err := g.record(ctx, append(pending, reply), resp); err != nil {
+			return resp, err
+		}
+	}
 
 	return resp, nil
 }
+
+// reserved counts the tokens a request needs besides the history.
+func (g *GoGPTChat) reserved() (int, error) {
+
+	usage := replyTokens(g.Query) + BUFF_MARGIN // the maximum size of the return message plus a buffer
+
+	overhead, err := queryOverhead(g.Query)
+
+	if err != nil {
+		return 0, err
+	}
+
+	queueSize, err := CountMessageTokens(g.MessageQueue, g.Query.Model)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if g.Memory != nil {
+		usage += g.Memory.budget()
+	}
+
+	return usage + overhead + queueSize, nil
+}
@@ -119,22 +119,14 @@ func TestChat(t *testing.T) {
 	var resp *GoGPTResponse
 	var err error
 
-	gpt, err := buildTestQueryHelper()
+	client := testClient(t)
 
-	if err != nil {
-		t.Errorf("error building test query: %s", err)
-	}
-
-	chat1 := NewGoGPTChat(gpt.Key)
-	chat1.Query.OrgName = gpt.OrgName
-	chat1.Query.OrgId = gpt.OrgId
+	chat1 := client.NewChat()
 	chat1.Query.MaxTokens = 500
 	chat1.Query.Model = MODEL_35_TURBO
 	chat1.AddMessage(ROLE_USER, "", "Hi").AddMessage(ROLE_SYSTEM, "", TEST_CHARACTER_PROMPT_1)
 
-	chat2 := NewGoGPTChat(gpt.Key)
-	chat2.Query.OrgName = gpt.OrgName
-	chat2.Query.OrgId = gpt.OrgId
+	chat2 := client.NewChat()
 	chat2.Query.MaxTokens = 500
 	chat1.Query.Model = MODEL_35_TURBO
 	chat2.AddMessage(ROLE_SYSTEM, "", TEST_CHARACTER_PROMPT_2)
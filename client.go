@@ -0,0 +1,228 @@
+package gogpt
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+/*
+	A Client holds the configuration and connection pool shared by every query, chat and embedding
+	request created from it. Build one per process (or per account) and hand out queries from it:
+
+	client := NewClient(key, WithOrganization("org-123"), WithProject("proj-456"), WithTimeout(time.Minute))
+	resp, err := client.NewQuery().AddMessage(ROLE_USER, "", "Can pigs fly?").Generate()
+
+	Queries created with NewGoGPTQuery share a package level default client instead.
+*/
+
+const (
+	API_BASE_URL          = "https://api.openai.com/v1"
+	CHAT_COMPLETIONS_PATH = "/chat/completions"
+	EMBEDDINGS_PATH       = "/embeddings"
+)
+
+type Client struct {
+	key        string
+	orgId      string
+	projectId  string
+	baseURL    string
+	timeout    time.Duration
+	headers    map[string]string
+	retry      *RetryPolicy
+	accountant *UsageAccountant
+	httpClient *http.Client
+	transport  http.RoundTripper
+	azure      *AzureConfig
+	resty      *resty.Client
+}
+
+type ClientOption func(*Client)
+
+var defaultClient = NewClient("")
+
+// WithOrganization bills requests to the given organization id instead of the key's default.
+func WithOrganization(id string) ClientOption {
+	return func(c *Client) {
+		c.orgId = id
+	}
+}
+
+// WithProject routes requests to the given project id.
+func WithProject(id string) ClientOption {
+	return func(c *Client) {
+		c.projectId = id
+	}
+}
+
+// WithBaseURL points the client at a different API root, such as a proxy or compatible server.
+func WithBaseURL(url string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(url, "/")
+	}
+}
+
+// WithHTTPClient sends requests through hc, for example one configured with a proxy or custom TLS.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithTransport sends requests through rt while keeping the default http.Client settings.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.transport = rt
+	}
+}
+
+// WithTimeout sets the default timeout for queries created by the client.
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithHeader adds a header to every request sent by the client.
+func WithHeader(name string, value string) ClientOption {
+	return func(c *Client) {
+		c.headers[name] = value
+	}
+}
+
+// WithRetryPolicy sets the default retry policy for queries and embeddings created by the client.
+func WithRetryPolicy(p *RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+// WithAccountant records the usage of every query and embedding created by the client.
+func WithAccountant(a *UsageAccountant) ClientOption {
+	return func(c *Client) {
+		c.accountant = a
+	}
+}
+
+func NewClient(key string, opts ...ClientOption) *Client {
+
+	d, _ := time.ParseDuration("30s")
+
+	c := &Client{
+		key:     key,
+		baseURL: API_BASE_URL,
+		timeout: d,
+		headers: map[string]string{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.httpClient != nil {
+		// resty sets its transport on the client it is given, so give it a copy of the caller's.
+		hc := *c.httpClient
+		c.resty = resty.NewWithClient(&hc)
+	} else {
+		c.resty = resty.New()
+	}
+
+	if c.transport != nil {
+		c.resty.SetTransport(c.transport)
+	}
+
+	c.resty.SetHeaders(c.headers)
+
+	return c
+}
+
+// NewQuery returns a query configured with the client's key, endpoint and defaults.
+func (c *Client) NewQuery() *GoGPTQuery {
+
+	q := NewGoGPTQuery(c.key)
+	q.client = c
+	q.OrgId = c.orgId
+	q.ProjectId = c.projectId
+	q.Endpoint = c.baseURL + CHAT_COMPLETIONS_PATH
+
+	// Azure URLs depend on the model, so they are worked out when the query is sent.
+	if c.azure != nil {
+		q.Endpoint = ""
+	}
+	q.Timeout = c.timeout
+	q.Retry = c.retry
+	q.Accountant = c.accountant
+
+	return q
+}
+
+// NewChat returns a chat whose queries are sent through the client.
+func (c *Client) NewChat() *GoGPTChat {
+	return &GoGPTChat{
+		Query: c.NewQuery(),
+	}
+}
+
+// NewEmbeddingsQuery returns an embeddings query configured with the client's key, endpoint and defaults.
+func (c *Client) NewEmbeddingsQuery() *GoGPTEmbeddingsQuery {
+
+	e := NewGoGPTEmbeddingsQuery(c.key)
+	e.client = c
+	e.OrgId = c.orgId
+	e.ProjectId = c.projectId
+	e.Endpoint = c.baseURL + EMBEDDINGS_PATH
+
+	if c.azure != nil {
+		e.Endpoint = ""
+	}
+	e.Timeout = c.timeout
+	e.Retry = c.retry
+	e.Accountant = c.accountant
+
+	return e
+}
+
+// GetEmbedding fetches the embedding for input through the client.
+func (c *Client) GetEmbedding(ctx context.Context, input string) (*GoGPTEmbeddings, error) {
+
+	e := c.NewEmbeddingsQuery()
+	e.Model = MODEL_EMBEDDING_ADA
+	e.Input = []string{input}
+
+	return e.GenerateWithContext(ctx)
+}
+
+func (c *Client) retryPolicy() *RetryPolicy {
+
+	if c.retry == nil {
+		return DefaultRetryPolicy()
+	}
+
+	return c.retry
+}
+
+// endpoint returns the URL of an operation, such as CHAT_COMPLETIONS_PATH, for model.
+func (c *Client) endpoint(path string, model string) string {
+
+	if c.azure != nil {
+		return c.azure.url(path, model)
+	}
+
+	return c.baseURL + path
+}
+
+// authorize adds the credentials and account headers to a request.
+func (c *Client) authorize(req *resty.Request, key string, orgId string, projectId string) {
+
+	if c.azure != nil {
+		req.SetHeader("api-key", key)
+		return
+	}
+
+	req.SetHeader("Authorization", "Bearer "+key)
+
+	setOrgHeaders(req, orgId, projectId)
+}
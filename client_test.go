@@ -0,0 +1,173 @@
+package gogpt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+type countingTransport struct {
+	calls int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestClient(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("unexpected authorization: %q", r.Header.Get("Authorization"))
+		}
+
+		if r.Header.Get("X-Team") != "search" {
+			t.Errorf("default header not sent: %+v", r.Header)
+		}
+
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			fmt.Fprint(w, testReply)
+		case "/v1/embeddings":
+			fmt.Fprint(w, `{"object":"list","model":"text-embedding-ada-002","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}]}`)
+		default:
+			t.Errorf("unexpected path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	rt := new(countingTransport)
+
+	client := NewClient("test-key",
+		WithBaseURL(server.URL+"/v1/"),
+		WithTransport(rt),
+		WithHeader("X-Team", "search"),
+	)
+
+	resp, err := client.NewQuery().AddMessage(ROLE_USER, "", "Can pigs fly?").Generate()
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if resp.Choices[0].Message.Content != "No." {
+		t.Errorf("unexpected reply: %+v", resp)
+	}
+
+	chat := client.NewChat()
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a farmer.").AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating chat: %v", err)
+	}
+
+	emb, err := client.GetEmbedding(context.Background(), "Hello, world!")
+
+	if err != nil {
+		t.Fatalf("error embedding: %v", err)
+	}
+
+	if len(emb.Data) != 1 || len(emb.Data[0].Embedding) != 2 {
+		t.Errorf("unexpected embedding: %+v", emb)
+	}
+
+	if rt.calls != 3 {
+		t.Errorf("expected 3 requests through the transport, got %d", rt.calls)
+	}
+}
+
+func TestClientKeepsHTTPClient(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	hc := &http.Client{Transport: http.DefaultTransport}
+	rt := new(countingTransport)
+
+	client := NewClient("test-key", WithBaseURL(server.URL), WithHTTPClient(hc), WithTransport(rt))
+
+	if _, err := client.NewQuery().AddMessage(ROLE_USER, "", "Can pigs fly?").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if rt.calls != 1 || hc.Transport != http.DefaultTransport {
+		t.Errorf("expected the transport on a copy of the caller's client, got %d calls and %T", rt.calls, hc.Transport)
+	}
+}
+
+func TestOrganizationHeaders(t *testing.T) {
+
+	type seen struct {
+		org     string
+		project string
+	}
+
+	var got []seen
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		got = append(got, seen{r.Header.Get("OpenAI-Organization"), r.Header.Get("OpenAI-Project")})
+
+		if r.URL.Path == "/embeddings" {
+			fmt.Fprint(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1]}]}`)
+			return
+		}
+
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-key", WithBaseURL(server.URL), WithOrganization("org-client"), WithProject("proj-client"))
+
+	// Client defaults.
+	if _, err := client.NewQuery().AddMessage(ROLE_USER, "", "Hi").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	// Per query override.
+	q := client.NewQuery()
+	q.OrgId = "org-query"
+	q.ProjectId = "proj-query"
+
+	if _, err := q.AddMessage(ROLE_USER, "", "Hi").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	// A standalone query with only an org set.
+	q = NewGoGPTQuery("test-key")
+	q.Endpoint = server.URL
+	q.OrgId = "org-standalone"
+
+	if _, err := q.AddMessage(ROLE_USER, "", "Hi").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if _, err := client.GetEmbedding(context.Background(), "Hi"); err != nil {
+		t.Fatalf("error embedding: %v", err)
+	}
+
+	want := []seen{
+		{"org-client", "proj-client"},
+		{"org-query", "proj-query"},
+		{"org-standalone", ""},
+		{"org-client", "proj-client"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d requests, got %d", len(want), len(got))
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
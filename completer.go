@@ -0,0 +1,74 @@
+package gogpt
+
+import (
+	"context"
+)
+
+/*
+	Completer and Embedder are the seams between the helpers in this package and the API. Chats,
+	summarizers, memories and structured output send their requests through them, so a fake, a
+	decorator that caches, logs or rate limits, or another provider can be dropped in:
+
+	logged := CompleterFunc(func(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error) {
+		log.Printf("sending %d messages to %s", len(q.Messages), q.Model)
+		return client.Complete(ctx, q)
+	})
+
+	chat := client.NewChat().SetCompleter(logged)
+
+	GoGPTQuery, GoGPTEmbeddingsQuery and Client implement both with the API. A query used as a
+	Completer sends other queries with its own key, endpoint and client, the way a Client does.
+*/
+
+type Completer interface {
+	Complete(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error)
+}
+
+type Embedder interface {
+	Embed(ctx context.Context, input []string) (*GoGPTEmbeddings, error)
+}
+
+type CompleterFunc func(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error)
+
+func (f CompleterFunc) Complete(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error) {
+	return f(ctx, q)
+}
+
+type EmbedderFunc func(ctx context.Context, input []string) (*GoGPTEmbeddings, error)
+
+func (f EmbedderFunc) Embed(ctx context.Context, input []string) (*GoGPTEmbeddings, error) {
+	return f(ctx, input)
+}
+
+// Complete sends q with g's credentials, endpoint, timeout, retry policy, accountant and client.
+func (g *GoGPTQuery) Complete(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error) {
+
+	if q == g {
+		return g.GenerateWithContext(ctx)
+	}
+
+	c := *q
+	g.connect(&c)
+
+	return c.GenerateWithContext(ctx)
+}
+
+// Embed embeds input with a copy of e, ignoring e's own Input and Tokens.
+func (e *GoGPTEmbeddingsQuery) Embed(ctx context.Context, input []string) (*GoGPTEmbeddings, error) {
+
+	c := *e
+	c.Input = input
+	c.Tokens = nil
+
+	return c.GenerateWithContext(ctx)
+}
+
+// Complete sends q through the client with the client's key, endpoint and defaults.
+func (c *Client) Complete(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error) {
+	return c.NewQuery().Complete(ctx, q)
+}
+
+// Embed embeds input through the client with the default embeddings model.
+func (c *Client) Embed(ctx context.Context, input []string) (*GoGPTEmbeddings, error) {
+	return c.NewEmbeddingsQuery().Embed(ctx, input)
+}
@@ -0,0 +1,170 @@
+package gogpt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testCompleter answers summaries with "summary" and everything else with "No.", remembering what it was sent.
+func testCompleter(sent *[]GoGPTQuery) Completer {
+	return CompleterFunc(func(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error) {
+
+		*sent = append(*sent, *q)
+
+		content := "No."
+
+		for _, tag := range q.Tags {
+			if tag == SUMMARY_TAG {
+				content = "summary"
+			}
+		}
+
+		return &GoGPTResponse{Model: q.Model, Choices: []GoGPTChoice{{Message: GoGPTMessage{Role: ROLE_ASSISTANT, Content: content}}}}, nil
+	})
+}
+
+func TestChatCompleter(t *testing.T) {
+
+	var sent []GoGPTQuery
+
+	// The endpoint is never contacted.
+	chat := testStrategyChat("http://127.0.0.1:1").SetCompleter(testCompleter(&sent))
+	chat.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	resp, err := chat.Generate()
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	history := chat.Query.Messages
+
+	if len(sent) != 1 || resp.Choices[0].Message.Content != "No." || history[len(history)-1].Content != "No." || history[len(history)-2].Content != "Can pigs fly?" {
+		t.Errorf("unexpected chat: %+v %+v", sent, history)
+	}
+
+	// Summaries go through the chat's completer too.
+	strategy := &RollingSummary{Keep: 2}
+
+	if err := strategy.Compact(context.Background(), chat, 10000); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	if len(sent) != 2 || chat.Summary != "summary" {
+		t.Errorf("expected a summary through the completer, got %+v %q", sent, chat.Summary)
+	}
+
+	// A summarizer's own completer wins.
+	var own []GoGPTQuery
+
+	s := chat.summarizer(&Summarizer{Completer: testCompleter(&own)})
+
+	if _, err := s.Summarize(context.Background(), chat.Query, "user: hi"); err != nil || len(own) != 1 || len(sent) != 2 {
+		t.Errorf("expected the summarizer's completer, got %v %d %d", err, len(own), len(sent))
+	}
+}
+
+func TestClientCompleter(t *testing.T) {
+
+	var auth []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	var completer Completer = NewClient("client-key", WithBaseURL(server.URL), WithRetryPolicy(&RetryPolicy{MaxAttempts: 1}))
+
+	q := NewGoGPTQuery("")
+	q.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	resp, err := completer.Complete(context.Background(), q)
+
+	if err != nil || resp.Choices[0].Message.Content != "No." {
+		t.Fatalf("unexpected reply: %+v %v", resp, err)
+	}
+
+	if len(auth) != 1 || auth[0] != "Bearer client-key" || q.Key != "" || q.Endpoint != API_ENDPOINT {
+		t.Errorf("expected the client's key without changing the query, got %v %+v", auth, q)
+	}
+
+	// A query sends others with its own connection.
+	base := NewGoGPTQuery("query-key")
+	base.Endpoint = server.URL
+
+	if _, err := base.Complete(context.Background(), q); err != nil || auth[1] != "Bearer query-key" {
+		t.Errorf("expected the query's key, got %v %v", auth, err)
+	}
+}
+
+func TestCompleteInto(t *testing.T) {
+
+	calls := 0
+
+	completer := CompleterFunc(func(ctx context.Context, q *GoGPTQuery) (*GoGPTResponse, error) {
+
+		calls++
+
+		content := `{"title":"Toast","ingredients":["bread"]}`
+
+		if calls == 1 {
+			content = "not json"
+		}
+
+		return &GoGPTResponse{Choices: []GoGPTChoice{{Message: GoGPTMessage{Role: ROLE_ASSISTANT, Content: content}}}}, nil
+	})
+
+	q := NewGoGPTQuery("test-key")
+	q.RepairAttempts = 1
+	q.AddMessage(ROLE_USER, "", "A recipe, please.")
+
+	recipe, _, err := CompleteInto[testRecipe](context.Background(), completer, q)
+
+	if err != nil || recipe.Title != "Toast" || calls != 2 || len(q.Messages) != 1 {
+		t.Errorf("unexpected result: %+v %v %d %d", recipe, err, calls, len(q.Messages))
+	}
+}
+
+func TestEmbedderFunc(t *testing.T) {
+
+	var inputs []string
+
+	embedder := EmbedderFunc(func(ctx context.Context, input []string) (*GoGPTEmbeddings, error) {
+
+		resp := &GoGPTEmbeddings{}
+
+		for i, text := range input {
+			inputs = append(inputs, text)
+			v := []float64{0, 1}
+			if strings.Contains(text, "pig") {
+				v = []float64{1, 0}
+			}
+			resp.Data = append(resp.Data, EmbeddingData{Index: i, Embedding: v})
+		}
+
+		return resp, nil
+	})
+
+	memory := NewChatMemory(embedder)
+	memory.TopK = 1
+
+	err := memory.Remember(context.Background(), []GoGPTMessage{
+		{Role: ROLE_USER, Content: "My pig is called Wilbur."},
+		{Role: ROLE_USER, Content: "The weather is nice."},
+	})
+
+	if err != nil {
+		t.Fatalf("error remembering: %v", err)
+	}
+
+	recalled, err := memory.Recall(context.Background(), "What is my pig called?")
+
+	if err != nil || len(recalled) != 1 || recalled[0].Content != "My pig is called Wilbur." || len(inputs) != 3 {
+		t.Errorf("unexpected recall: %+v %v %v", recalled, err, inputs)
+	}
+}
@@ -1,53 +1,313 @@
 package gogpt
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 )
 
+/*
+	Based on the embeddings documentation here: https://platform.openai.com/docs/api-reference/embeddings
+
+	The API accepts up to EMBEDDINGS_BATCH_SIZE inputs and EMBEDDINGS_BATCH_TOKENS tokens, summed
+	across inputs, per request. GoGPTEmbeddingsQuery splits larger inputs into batches under both
+	limits, sends them in order, and returns one GoGPTEmbeddings with Data indexed against the
+	original input.
+*/
+
+const (
+	MODEL_EMBEDDING_3_SMALL = "text-embedding-3-small"
+	MODEL_EMBEDDING_3_LARGE = "text-embedding-3-large"
+	ENCODING_FORMAT_FLOAT   = "float"
+	ENCODING_FORMAT_BASE64  = "base64"
+	EMBEDDINGS_BATCH_SIZE   = 2048
+	EMBEDDINGS_BATCH_TOKENS = 300000
+)
+
 type EmbeddingData struct {
 	Embedding []float64 `json:"embedding"`
 	Index     int       `json:"index"`
 	Object    string    `json:"object"`
 }
 
+// UnmarshalJSON accepts embeddings as a list of floats or as base64 encoded little-endian float32s.
+func (e *EmbeddingData) UnmarshalJSON(data []byte) error {
+
+	type plain EmbeddingData
+
+	raw := struct {
+		plain
+		Embedding json.RawMessage `json:"embedding"`
+	}{}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*e = EmbeddingData(raw.plain)
+
+	if len(raw.Embedding) == 0 || raw.Embedding[0] != '"' {
+		return json.Unmarshal(raw.Embedding, &e.Embedding)
+	}
+
+	var encoded string
+
+	if err := json.Unmarshal(raw.Embedding, &encoded); err != nil {
+		return err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return err
+	}
+
+	if len(b)%4 != 0 {
+		return fmt.Errorf("invalid base64 embedding length %d", len(b))
+	}
+
+	e.Embedding = make([]float64, len(b)/4)
+
+	for i := range e.Embedding {
+		e.Embedding[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:])))
+	}
+
+	return nil
+}
+
 type GoGPTEmbeddings struct {
+	Error  *GoGPTError     `json:"error,omitempty"`
 	Model  string          `json:"model"`
 	Object string          `json:"object"`
 	Data   []EmbeddingData `json:"data"`
 	Usage  GoGPTUsage      `json:"usage"`
 }
 
+/*
+	Input is a string, a list of strings, or a list of token arrays.
+*/
+
 type GoGPTEmbeddingsRequest struct {
-	Input string `json:"input"`
-	Model string `json:"model"`
+	Input          interface{} `json:"input"`
+	Model          string      `json:"model"`
+	Dimensions     int         `json:"dimensions,omitempty"`
+	EncodingFormat string      `json:"encoding_format,omitempty"`
+	User           string      `json:"user,omitempty"`
+}
+
+/*
+	Only Key and one of Input or Tokens are required.
+*/
+
+type GoGPTEmbeddingsQuery struct {
+	Model          string
+	Input          []string
+	Tokens         [][]int
+	Dimensions     int
+	EncodingFormat string
+	User           string
+	BatchSize      int
+	BatchTokens    int
+	Key            string
+	OrgId          string
+	ProjectId      string
+	Endpoint       string
+	Timeout        time.Duration
+	Retry          *RetryPolicy
+	Accountant     *UsageAccountant
+	Tags           []string
+	client         *Client
+}
+
+func NewGoGPTEmbeddingsQuery(key string) *GoGPTEmbeddingsQuery {
+
+	d, _ := time.ParseDuration("30s")
+
+	return &GoGPTEmbeddingsQuery{
+		Key:         key,
+		Model:       MODEL_EMBEDDING_3_SMALL,
+		Endpoint:    EMBEDDINGS_ENDPOINT,
+		BatchSize:   EMBEDDINGS_BATCH_SIZE,
+		BatchTokens: EMBEDDINGS_BATCH_TOKENS,
+		Timeout:     d,
+	}
 }
 
 func GetEmbedding(input string, key string) (*GoGPTEmbeddings, error) {
+	return GetEmbeddingWithContext(context.Background(), input, key)
+}
 
-	embeddingsReq := GoGPTEmbeddingsRequest{
-		Input: input,
-		Model: MODEL_EMBEDDING_ADA,
+// GetEmbeddingWithContext is like GetEmbedding but aborts the request when ctx is done.
+func GetEmbeddingWithContext(ctx context.Context, input string, key string) (*GoGPTEmbeddings, error) {
+
+	q := NewGoGPTEmbeddingsQuery(key)
+	q.Model = MODEL_EMBEDDING_ADA
+	q.Input = []string{input}
+
+	return q.GenerateWithContext(ctx)
+}
+
+func (e *GoGPTEmbeddingsQuery) Generate() (*GoGPTEmbeddings, error) {
+	return e.GenerateWithContext(context.Background())
+}
+
+// GenerateWithContext embeds every input, batching as needed, and returns the results in input order.
+func (e *GoGPTEmbeddingsQuery) GenerateWithContext(ctx context.Context) (*GoGPTEmbeddings, error) {
+
+	if len(e.Input) > 0 && len(e.Tokens) > 0 {
+		return nil, fmt.Errorf("input and tokens cannot be mixed")
 	}
 
-	timeout, _ := time.ParseDuration("30s")
+	var inputs []interface{}
 
-	client := resty.New()
+	for _, s := range e.Input {
+		if len(s) == 0 {
+			return nil, fmt.Errorf("empty input provided")
+		}
+		inputs = append(inputs, s)
+	}
+
+	for _, t := range e.Tokens {
+		inputs = append(inputs, t)
+	}
 
-	client.SetTimeout(timeout)
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no input provided")
+	}
 
-	if len(input) == 0 {
-		return nil, fmt.Errorf("no messages provided")
+	// Embeddings are cheap to estimate poorly, so only refuse once the budget is already spent.
+	if e.Accountant != nil {
+		if err := e.Accountant.Allow(e.Model, GoGPTUsage{}); err != nil {
+			return nil, err
+		}
 	}
 
-	resp, err := client.R().
-		SetHeader("Authorization", "Bearer "+key).
+	result := &GoGPTEmbeddings{Object: "list"}
+
+	for _, b := range e.batches(inputs) {
+
+		start := b[0]
+
+		batch, err := e.send(ctx, inputs[b[0]:b[1]])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range batch.Data {
+			d.Index += start
+			result.Data = append(result.Data, d)
+		}
+
+		result.Model = batch.Model
+		result.Usage.PromptTokens += batch.Usage.PromptTokens
+		result.Usage.TotalTokens += batch.Usage.TotalTokens
+
+		if e.Accountant != nil {
+			e.Accountant.Record(e.Model, e.User, e.Tags, batch.Usage)
+		}
+	}
+
+	sort.Slice(result.Data, func(i, j int) bool {
+		return result.Data[i].Index < result.Data[j].Index
+	})
+
+	return result, nil
+}
+
+// batches splits inputs into [start, end) ranges under both the input and the token limits of a request.
+func (e *GoGPTEmbeddingsQuery) batches(inputs []interface{}) [][2]int {
+
+	size := e.BatchSize
+
+	if size <= 0 || size > EMBEDDINGS_BATCH_SIZE {
+		size = EMBEDDINGS_BATCH_SIZE
+	}
+
+	limit := e.BatchTokens
+
+	if limit <= 0 || limit > EMBEDDINGS_BATCH_TOKENS {
+		limit = EMBEDDINGS_BATCH_TOKENS
+	}
+
+	var batches [][2]int
+
+	start, tokens := 0, 0
+
+	for i, input := range inputs {
+
+		n := 0
+
+		switch v := input.(type) {
+		case string:
+			// Without a tokenizer, bytes are a safe overestimate of tokens.
+			if count, err := CountTokens(v, e.Model); err == nil {
+				n = count
+			} else {
+				n = len(v)
+			}
+		case []int:
+			n = len(v)
+		}
+
+		// An input over the limit on its own still gets a batch, and the API's error.
+		if i > start && (i-start == size || tokens+n > limit) {
+			batches = append(batches, [2]int{start, i})
+			start, tokens = i, 0
+		}
+
+		tokens += n
+	}
+
+	return append(batches, [2]int{start, len(inputs)})
+}
+
+func (e *GoGPTEmbeddingsQuery) send(ctx context.Context, inputs []interface{}) (*GoGPTEmbeddings, error) {
+
+	client := e.client
+
+	if client == nil {
+		client = defaultClient
+	}
+
+	retry := e.Retry
+
+	if retry == nil {
+		retry = client.retryPolicy()
+	}
+
+	embeddingsReq := GoGPTEmbeddingsRequest{
+		Input:          inputs,
+		Model:          e.Model,
+		Dimensions:     e.Dimensions,
+		EncodingFormat: e.EncodingFormat,
+		User:           e.User,
+	}
+
+	req := client.resty.R().
 		SetHeader("Content-Type", "application/json").
-		SetBody(embeddingsReq).
-		Post(EMBEDDINGS_ENDPOINT)
+		SetBody(embeddingsReq)
+
+	client.authorize(req, e.Key, e.OrgId, e.ProjectId)
+
+	endpoint := e.Endpoint
+
+	if endpoint == "" {
+		endpoint = client.endpoint(EMBEDDINGS_PATH, e.Model)
+	}
+
+	resp, err := retry.Do(ctx, func() (*resty.Response, error) {
+		actx, cancel := e.withTimeout(ctx)
+		defer cancel()
+		return req.SetContext(actx).Post(endpoint)
+	})
 
 	if err != nil {
 		return nil, err
@@ -57,8 +317,24 @@ func GetEmbedding(input string, key string) (*GoGPTEmbeddings, error) {
 	err = json.Unmarshal(resp.Body(), &embResp)
 
 	if err != nil {
+		if resp.IsError() {
+			return nil, newAPIError(resp, nil)
+		}
 		return nil, err
 	}
 
+	if embResp.Error != nil || resp.IsError() {
+		return nil, newAPIError(resp, embResp.Error)
+	}
+
 	return embResp, nil
 }
+
+func (e *GoGPTEmbeddingsQuery) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	if e.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, e.Timeout)
+}
@@ -1,23 +1,170 @@
 package gogpt
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestEmbedding(t *testing.T) {
 
-	gpt, err := buildTestQueryHelper()
+	emb, err := testClient(t).GetEmbedding(context.Background(), "Hello, world!")
 
 	if err != nil {
-		t.Errorf("Error building config: %v", err)
+		t.Errorf("Error embeddings: %v", err)
 	}
 
-	emb, err := GetEmbedding("Hello, world!", gpt.Key)
+	t.Logf("Embeddings: %+v", emb)
+
+}
+
+func TestEmbeddingsBatching(t *testing.T) {
+
+	var batches [][]string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		req := struct {
+			Input      []string `json:"input"`
+			Model      string   `json:"model"`
+			Dimensions int      `json:"dimensions"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		batches = append(batches, req.Input)
+
+		if req.Model != MODEL_EMBEDDING_3_LARGE || req.Dimensions != 2 {
+			t.Errorf("unexpected request: %+v", req)
+		}
+
+		// Reply out of order to check results are re-ordered by index.
+		resp := GoGPTEmbeddings{Object: "list", Model: req.Model}
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			resp.Data = append(resp.Data, EmbeddingData{Index: i, Object: "embedding", Embedding: []float64{float64(len(req.Input[i])), 0}})
+		}
+		resp.Usage.PromptTokens = len(req.Input)
+		resp.Usage.TotalTokens = len(req.Input)
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	q := NewGoGPTEmbeddingsQuery("test-key")
+	q.Endpoint = server.URL
+	q.Model = MODEL_EMBEDDING_3_LARGE
+	q.Dimensions = 2
+	q.BatchSize = 2
+	q.Input = []string{"a", "bb", "ccc", "dddd", "eeeee"}
+
+	emb, err := q.Generate()
 
 	if err != nil {
-		t.Errorf("Error embeddings: %v", err)
+		t.Fatalf("error embedding: %v", err)
 	}
 
-	t.Logf("Embeddings: %+v", emb)
+	if len(batches) != 3 || len(batches[2]) != 1 {
+		t.Errorf("unexpected batches: %v", batches)
+	}
+
+	if len(emb.Data) != 5 || emb.Usage.TotalTokens != 5 {
+		t.Fatalf("unexpected result: %+v", emb)
+	}
+
+	for i, d := range emb.Data {
+		if d.Index != i || d.Embedding[0] != float64(i+1) {
+			t.Errorf("result %d out of order: %+v", i, d)
+		}
+	}
+}
+
+func TestEmbeddingsTokenBatching(t *testing.T) {
+
+	var batches []int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		req := struct {
+			Input []interface{} `json:"input"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		batches = append(batches, len(req.Input))
+
+		resp := GoGPTEmbeddings{Object: "list"}
+		for i := range req.Input {
+			resp.Data = append(resp.Data, EmbeddingData{Index: i, Object: "embedding", Embedding: []float64{1, 0}})
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
 
+	text := "Pigs can't fly, but they can swim."
+
+	n, err := CountTokens(text, MODEL_EMBEDDING_3_SMALL)
+
+	if err != nil {
+		t.Fatalf("error counting tokens: %v", err)
+	}
+
+	// Two inputs fit under the token cap, well before the count limit.
+	q := NewGoGPTEmbeddingsQuery("test-key")
+	q.Endpoint = server.URL
+	q.BatchTokens = 2*n + 1
+	q.Input = []string{text, text, text, text, text}
+
+	emb, err := q.Generate()
+
+	if err != nil {
+		t.Fatalf("error embedding: %v", err)
+	}
+
+	if len(batches) != 3 || batches[0] != 2 || batches[2] != 1 || len(emb.Data) != 5 || emb.Data[4].Index != 4 {
+		t.Errorf("unexpected batches %v for %+v", batches, emb)
+	}
+
+	// Token arrays count their length, and an input over the cap goes alone.
+	batches = nil
+	q.Input = nil
+	q.BatchTokens = 4
+	q.Tokens = [][]int{{1, 2}, {3, 4}, {5, 6, 7, 8, 9}, {10}}
+
+	if _, err := q.Generate(); err != nil || len(batches) != 3 || batches[0] != 2 || batches[1] != 1 {
+		t.Errorf("unexpected batches %v: %v", batches, err)
+	}
+}
+
+func TestEmbeddingsBase64(t *testing.T) {
+
+	values := []float32{0.5, -1.25, 3}
+	buf := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(v))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"object":"list","data":[{"object":"embedding","index":0,"embedding":"%s"}]}`, base64.StdEncoding.EncodeToString(buf))
+	}))
+	defer server.Close()
+
+	q := NewGoGPTEmbeddingsQuery("test-key")
+	q.Endpoint = server.URL
+	q.EncodingFormat = ENCODING_FORMAT_BASE64
+	q.Tokens = [][]int{{9906, 11, 1917}}
+
+	emb, err := q.Generate()
+
+	if err != nil {
+		t.Fatalf("error embedding: %v", err)
+	}
+
+	got := emb.Data[0].Embedding
+
+	if len(got) != 3 || got[0] != 0.5 || got[1] != -1.25 || got[2] != 3 {
+		t.Errorf("unexpected decoded embedding: %v", got)
+	}
 }
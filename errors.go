@@ -0,0 +1,132 @@
+package gogpt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+/*
+	Error codes are described here: https://platform.openai.com/docs/guides/error-codes
+
+	Every failed API call is returned as an *APIError. Use errors.As to get at the details, or
+	errors.Is with one of the sentinels below to branch on the kind of failure:
+
+	if errors.Is(err, ErrRateLimited) {
+		// back off
+	}
+*/
+
+var (
+	ErrRateLimited           = errors.New("rate limited")
+	ErrInsufficientQuota     = errors.New("insufficient quota")
+	ErrContextLengthExceeded = errors.New("context length exceeded")
+	ErrInvalidAPIKey         = errors.New("invalid api key")
+	ErrServer                = errors.New("server error")
+)
+
+// RateLimit holds the x-ratelimit-* headers returned with a response.
+type RateLimit struct {
+	LimitRequests     int
+	LimitTokens       int
+	RemainingRequests int
+	RemainingTokens   int
+	ResetRequests     time.Duration
+	ResetTokens       time.Duration
+}
+
+type APIError struct {
+	StatusCode int
+	RequestId  string
+	Message    string
+	Type       string
+	Param      string
+	Code       string
+	RateLimit  RateLimit
+}
+
+func (e *APIError) Error() string {
+
+	msg := e.Message
+
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("error: %s", msg)
+	}
+
+	return fmt.Sprintf("error: %s (status %d)", msg, e.StatusCode)
+}
+
+// Is lets errors.Is match an *APIError against the sentinel errors.
+func (e *APIError) Is(target error) bool {
+
+	switch target {
+	case ErrInsufficientQuota:
+		return e.Code == "insufficient_quota"
+	case ErrRateLimited:
+		return e.Code == "rate_limit_exceeded" || (e.StatusCode == http.StatusTooManyRequests && e.Code != "insufficient_quota")
+	case ErrContextLengthExceeded:
+		return e.Code == "context_length_exceeded"
+	case ErrInvalidAPIKey:
+		return e.Code == "invalid_api_key" || e.StatusCode == http.StatusUnauthorized
+	case ErrServer:
+		return e.StatusCode >= 500 || e.Type == "server_error"
+	}
+
+	return false
+}
+
+// newAPIError builds an *APIError from a response and the error object in its body, if any.
+func newAPIError(resp *resty.Response, body *GoGPTError) *APIError {
+
+	e := new(APIError)
+
+	if resp != nil {
+		e.StatusCode = resp.StatusCode()
+		e.RequestId = resp.Header().Get("x-request-id")
+		e.RateLimit = parseRateLimit(resp.Header())
+	}
+
+	if body != nil {
+		e.Message = body.Message
+		e.Type = body.ErrType
+		e.Param = body.Param
+		if body.Code != nil {
+			e.Code = fmt.Sprint(body.Code)
+		}
+	} else if resp != nil {
+		e.Message = strings.TrimSpace(string(resp.Body()))
+	}
+
+	return e
+}
+
+func parseRateLimit(h http.Header) RateLimit {
+
+	atoi := func(name string) int {
+		n, _ := strconv.Atoi(h.Get(name))
+		return n
+	}
+
+	duration := func(name string) time.Duration {
+		d, _ := time.ParseDuration(h.Get(name))
+		return d
+	}
+
+	return RateLimit{
+		LimitRequests:     atoi("x-ratelimit-limit-requests"),
+		LimitTokens:       atoi("x-ratelimit-limit-tokens"),
+		RemainingRequests: atoi("x-ratelimit-remaining-requests"),
+		RemainingTokens:   atoi("x-ratelimit-remaining-tokens"),
+		ResetRequests:     duration("x-ratelimit-reset-requests"),
+		ResetTokens:       duration("x-ratelimit-reset-tokens"),
+	}
+}
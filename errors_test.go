@@ -0,0 +1,113 @@
+package gogpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIError(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-request-id", "req_123")
+		w.Header().Set("x-ratelimit-limit-requests", "500")
+		w.Header().Set("x-ratelimit-remaining-requests", "0")
+		w.Header().Set("x-ratelimit-reset-requests", "120ms")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"Rate limit reached","type":"requests","param":null,"code":"rate_limit_exceeded"}}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.Retry = &RetryPolicy{MaxAttempts: 1}
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	_, err := gpt.Generate()
+
+	var apiErr *APIError
+
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *APIError, got %v", err)
+	}
+
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.RequestId != "req_123" {
+		t.Errorf("unexpected error details: %+v", apiErr)
+	}
+
+	if apiErr.Type != "requests" || apiErr.Code != "rate_limit_exceeded" {
+		t.Errorf("unexpected error type: %+v", apiErr)
+	}
+
+	if apiErr.RateLimit.LimitRequests != 500 || apiErr.RateLimit.ResetRequests != 120*time.Millisecond {
+		t.Errorf("unexpected rate limit: %+v", apiErr.RateLimit)
+	}
+
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited")
+	}
+
+	if errors.Is(err, ErrInvalidAPIKey) || errors.Is(err, ErrInsufficientQuota) {
+		t.Errorf("error matched the wrong sentinel")
+	}
+}
+
+func TestAPIErrorSentinels(t *testing.T) {
+
+	cases := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusBadRequest, `{"error":{"message":"too long","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`, ErrContextLengthExceeded},
+		{http.StatusUnauthorized, `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"invalid_api_key"}}`, ErrInvalidAPIKey},
+		{http.StatusTooManyRequests, `{"error":{"message":"quota","type":"insufficient_quota","code":"insufficient_quota"}}`, ErrInsufficientQuota},
+		{http.StatusBadGateway, `bad gateway`, ErrServer},
+	}
+
+	for _, c := range cases {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			fmt.Fprint(w, c.body)
+		}))
+
+		gpt := NewGoGPTQuery("test-key")
+		gpt.Endpoint = server.URL
+		gpt.Retry = &RetryPolicy{MaxAttempts: 1}
+		gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+		_, err := gpt.Generate()
+
+		if !errors.Is(err, c.want) {
+			t.Errorf("status %d: expected %v, got %v", c.status, c.want, err)
+		}
+
+		if c.want == ErrInsufficientQuota && errors.Is(err, ErrRateLimited) {
+			t.Errorf("insufficient quota should not be treated as a rate limit")
+		}
+
+		server.Close()
+	}
+}
+
+func TestEmbeddingAPIError(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"invalid_api_key"}}`)
+	}))
+	defer server.Close()
+
+	client := NewClient("bad-key", WithBaseURL(server.URL))
+
+	_, err := client.GetEmbedding(context.Background(), "Hello, world!")
+
+	if !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey, got %v", err)
+	}
+}
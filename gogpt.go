@@ -1,6 +1,7 @@
 package gogpt
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -29,6 +30,7 @@ const (
 	ROLE_USER           = "user"
 	ROLE_ASSISTANT      = "assistant"
 	ROLE_FUNCTION       = "function"
+	ROLE_TOOL           = "tool"
 	RETRIES             = 3
 )
 
@@ -38,7 +40,7 @@ type GoGPTFunctionCall struct {
 }
 
 /*
-	Role is an enum of system, user, assistant, or function.
+	Role is an enum of system, user, assistant, function, or tool.
 */
 
 type GoGPTMessage struct {
@@ -46,6 +48,9 @@ type GoGPTMessage struct {
 	Content      string             `json:"content"`
 	Name         string             `json:"name,omitempty"`
 	FunctionCall *GoGPTFunctionCall `json:"function_call,omitempty"`
+	ToolCalls    []GoGPTToolCall    `json:"tool_calls,omitempty"`
+	ToolCallId   string             `json:"tool_call_id,omitempty"`
+	Refusal      string             `json:"refusal,omitempty"`
 }
 
 type GoGPTChoice struct {
@@ -88,24 +93,35 @@ type GoGPTFunction struct {
 */
 
 type GoGPTQuery struct {
-	Model           string             `json:"model"`
-	Messages        []GoGPTMessage     `json:"messages"`
-	Functions       []GoGPTFunction    `json:"functions,omitempty"`
-	FunctionCall    string             `json:"function_call,omitempty"`
-	Temperature     float32            `json:"temperature,omitempty"`
-	TopP            float32            `json:"top_p,omitempty"`
-	N               int                `json:"n,omitempty"`
-	Stream          bool               `json:"stream,omitempty"`
-	Stop            string             `json:"stop,omitempty"`
-	MaxTokens       int                `json:"max_tokens,omitempty"`
-	PresencePenalty float32            `json:"presence_penalty,omitempty"`
-	LogitBias       map[string]float32 `json:"logit_bias,omitempty"`
-	User            string             `json:"user,omitempty"`
-	Key             string             `json:"-"`
-	OrgName         string             `json:"-"`
-	OrgId           string             `json:"-"`
-	Endpoint        string             `json:"-"`
-	Timeout         time.Duration      `json:"-"`
+	Model             string               `json:"model"`
+	Messages          []GoGPTMessage       `json:"messages"`
+	Functions         []GoGPTFunction      `json:"functions,omitempty"`
+	FunctionCall      string               `json:"function_call,omitempty"`
+	Tools             []GoGPTTool          `json:"tools,omitempty"`
+	ToolChoice        interface{}          `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	Temperature       float32              `json:"temperature,omitempty"`
+	TopP              float32              `json:"top_p,omitempty"`
+	N                 int                  `json:"n,omitempty"`
+	Stream            bool                 `json:"stream,omitempty"`
+	StreamOptions     *GoGPTStreamOptions  `json:"stream_options,omitempty"`
+	Stop              string               `json:"stop,omitempty"`
+	MaxTokens         int                  `json:"max_tokens,omitempty"`
+	PresencePenalty   float32              `json:"presence_penalty,omitempty"`
+	LogitBias         map[string]float32   `json:"logit_bias,omitempty"`
+	User              string               `json:"user,omitempty"`
+	ResponseFormat    *GoGPTResponseFormat `json:"response_format,omitempty"`
+	Key               string               `json:"-"`
+	OrgName           string               `json:"-"`
+	OrgId             string               `json:"-"`
+	ProjectId         string               `json:"-"`
+	Endpoint          string               `json:"-"`
+	Timeout           time.Duration        `json:"-"`
+	Retry             *RetryPolicy         `json:"-"`
+	RepairAttempts    int                  `json:"-"`
+	Accountant        *UsageAccountant     `json:"-"`
+	Tags              []string             `json:"-"`
+	client            *Client
 }
 
 func NewGoGPTQuery(key string) *GoGPTQuery {
@@ -124,13 +140,14 @@ func NewGoGPTQuery(key string) *GoGPTQuery {
 	}
 }
 
-func (g *GoGPTQuery) AddFunction(name string, desc string, obj interface{}) (*GoGPTQuery, error) {
+// reflectFunction describes a function whose parameters are the fields of obj.
+func reflectFunction(name string, desc string, obj interface{}) (GoGPTFunction, error) {
 
 	fjson := jsonschema.Reflect(obj)
 	tname := reflect.TypeOf(obj).Name()
 
 	if tname == "" {
-		return nil, fmt.Errorf("could not determine type name")
+		return GoGPTFunction{}, fmt.Errorf("could not determine type name")
 	}
 
 	f := GoGPTFunction{
@@ -139,6 +156,17 @@ func (g *GoGPTQuery) AddFunction(name string, desc string, obj interface{}) (*Go
 		Parameters:  fjson.Definitions[tname],
 	}
 
+	return f, nil
+}
+
+func (g *GoGPTQuery) AddFunction(name string, desc string, obj interface{}) (*GoGPTQuery, error) {
+
+	f, err := reflectFunction(name, desc, obj)
+
+	if err != nil {
+		return nil, err
+	}
+
 	g.Functions = append(g.Functions, f)
 
 	return g, nil
@@ -157,58 +185,168 @@ func (g *GoGPTQuery) AddMessage(role string, name string, content string) *GoGPT
 	return g
 }
 
-func (g *GoGPTQuery) send() (*resty.Response, error) {
+func (g *GoGPTQuery) request() (*resty.Request, error) {
 
 	if g.Model == "" {
 		g.Model = MODEL_35_TURBO
 	}
 
-	client := resty.New()
-	client.SetTimeout(g.Timeout)
+	// Tokenize the prompt once for both the context check and the budget.
+	prompt, err := -1, error(nil)
 
-	if len(g.Messages) == 0 {
-		return nil, fmt.Errorf("no messages provided")
+	if g.Accountant != nil || g.checksContext() {
+		prompt, err = CountQueryTokens(g)
 	}
 
-	resp, err := client.R().
-		SetHeader("Authorization", "Bearer "+g.Key).
-		SetHeader("Content-Type", "application/json").
-		SetBody(g).
-		Post(g.Endpoint)
+	checked := prompt
 
 	if err != nil {
+		checked = -1
+	}
+
+	if verr := g.validate(checked); verr != nil {
+		return nil, verr
+	}
+
+	if err := g.allow(prompt, err); err != nil {
 		return nil, err
 	}
 
-	return resp, nil
+	client := g.sender()
+
+	req := client.resty.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(g)
+
+	client.authorize(req, g.Key, g.OrgId, g.ProjectId)
+
+	return req, nil
+}
+
+// sender returns the client that sends the query.
+func (g *GoGPTQuery) sender() *Client {
+
+	if g.client == nil {
+		return defaultClient
+	}
+
+	return g.client
 }
 
-func (g *GoGPTQuery) Generate() (*GoGPTResponse, error) {
+// endpoint is the URL the query is sent to: Endpoint, or the client's URL for the model if that is empty.
+func (g *GoGPTQuery) endpoint() string {
 
-	var resp *resty.Response
-	var err error
+	if g.Endpoint != "" {
+		return g.Endpoint
+	}
 
-	gptResp := new(GoGPTResponse)
+	return g.sender().endpoint(CHAT_COMPLETIONS_PATH, g.Model)
+}
 
-	for i := 0; i < RETRIES; i++ {
-		if resp == nil {
-			resp, err = g.send()
-		}
+// setOrgHeaders routes a request to an organization and project rather than the key's defaults.
+func setOrgHeaders(req *resty.Request, orgId string, projectId string) {
+
+	if orgId != "" {
+		req.SetHeader("OpenAI-Organization", orgId)
+	}
+
+	if projectId != "" {
+		req.SetHeader("OpenAI-Project", projectId)
+	}
+}
+
+// withTimeout bounds a single attempt by the query's Timeout.
+func (g *GoGPTQuery) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	if g.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, g.Timeout)
+}
+
+func (g *GoGPTQuery) retryPolicy() *RetryPolicy {
+
+	if g.Retry != nil {
+		return g.Retry
+	}
+
+	if g.client != nil {
+		return g.client.retryPolicy()
+	}
+
+	return DefaultRetryPolicy()
+}
+
+func (g *GoGPTQuery) send(ctx context.Context) (*resty.Response, error) {
+
+	req, err := g.request()
+
+	if err != nil {
+		return nil, err
 	}
 
+	return g.retryPolicy().Do(ctx, func() (*resty.Response, error) {
+		actx, cancel := g.withTimeout(ctx)
+		defer cancel()
+		return req.SetContext(actx).Post(g.endpoint())
+	})
+}
+
+// connect points q at the same server as g, with the same credentials.
+func (g *GoGPTQuery) connect(q *GoGPTQuery) {
+
+	q.Key = g.Key
+	q.OrgName = g.OrgName
+	q.OrgId = g.OrgId
+	q.ProjectId = g.ProjectId
+	q.Endpoint = g.Endpoint
+	q.Timeout = g.Timeout
+	q.Retry = g.Retry
+	q.Accountant = g.Accountant
+	q.client = g.client
+}
+
+// derive returns a fresh query that talks to the same server with the same credentials.
+func (g *GoGPTQuery) derive() *GoGPTQuery {
+
+	q := NewGoGPTQuery(g.Key)
+	g.connect(q)
+	q.User = g.User
+	q.Tags = append([]string{}, g.Tags...)
+
+	return q
+}
+
+func (g *GoGPTQuery) Generate() (*GoGPTResponse, error) {
+	return g.GenerateWithContext(context.Background())
+}
+
+// GenerateWithContext is like Generate but aborts the request when ctx is cancelled or its deadline passes.
+func (g *GoGPTQuery) GenerateWithContext(ctx context.Context) (*GoGPTResponse, error) {
+
+	resp, err := g.send(ctx)
+
 	if err != nil {
 		return nil, err
 	}
 
+	gptResp := new(GoGPTResponse)
+
 	err = json.Unmarshal(resp.Body(), &gptResp)
 
 	if err != nil {
+		if resp.IsError() {
+			return nil, newAPIError(resp, nil)
+		}
 		return nil, err
 	}
 
-	if gptResp.Error != nil {
-		return nil, fmt.Errorf("error: %v", gptResp.Error.Message)
+	if gptResp.Error != nil || resp.IsError() {
+		return nil, newAPIError(resp, gptResp.Error)
 	}
 
+	g.record(gptResp)
+
 	return gptResp, nil
 }
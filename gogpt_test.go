@@ -1,20 +1,53 @@
 package gogpt
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
 )
 
 type TestConfig struct {
 	GptKey     string `json:"gpt_key"`
 	GptOrgName string `json:"gpt_org_name"`
 	GptOrgId   string `json:"gpt_org_id"`
+	GptProject string `json:"gpt_project_id"`
 }
 
-// Simple helper function to build a test query.
-func buildTestQueryHelper() (*GoGPTQuery, error) {
+// byteBpeLoader is a stand-in vocabulary of single bytes, used when the real encodings can't be downloaded.
+// Other packages call gogpttest.UseOfflineTokenizer instead, which this package's tests can't import.
+type byteBpeLoader struct{}
+
+func (byteBpeLoader) LoadTiktokenBpe(file string) (map[string]int, error) {
+
+	ranks := map[string]int{}
+
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+
+	return ranks, nil
+}
+
+func TestMain(m *testing.M) {
+
+	// Token counts are only approximate offline, but budgeting code can still be exercised.
+	if _, err := tiktoken.GetEncoding(ENCODING_CL100K); err != nil {
+		tiktoken.SetBpeLoader(byteBpeLoader{})
+	}
+
+	os.Exit(m.Run())
+}
+
+// loadTestConfig reads the API settings from the environment, or failing that from testconfig.json.
+func loadTestConfig() (*TestConfig, error) {
 
 	conf := new(TestConfig)
 
@@ -23,6 +56,7 @@ func buildTestQueryHelper() (*GoGPTQuery, error) {
 	conf.GptKey = os.Getenv("OPENAI_KEY")
 	conf.GptOrgId = os.Getenv("OPENAI_ORG_ID")
 	conf.GptOrgName = os.Getenv("OPENAI_ORG_NAME")
+	conf.GptProject = os.Getenv("OPENAI_PROJECT_ID")
 
 	// If that fails, try to pull them from a file. Use key to test.
 	if len(conf.GptKey) == 0 {
@@ -41,22 +75,76 @@ func buildTestQueryHelper() (*GoGPTQuery, error) {
 		}
 	}
 
-	gpt := NewGoGPTQuery(conf.GptKey)
-	gpt.OrgName = conf.GptOrgName
-	gpt.OrgId = conf.GptOrgId
+	return conf, nil
+}
+
+/*
+	testClient returns a client for tests that talk to the API. With GOGPT_CASSETTES=record the live
+	API is used and the traffic is saved to testdata/cassettes/<test name>.jsonl. Otherwise a saved
+	cassette is replayed if there is one, the live API is used if there is a key, and the test is
+	skipped if there is neither.
+*/
+
+func testClient(t *testing.T) *Client {
+
+	path := filepath.Join("testdata", "cassettes", strings.ReplaceAll(t.Name(), "/", "_")+".jsonl")
+
+	conf, confErr := loadTestConfig()
+
+	if os.Getenv("GOGPT_CASSETTES") == CASSETTE_RECORD {
+
+		if confErr != nil {
+			t.Fatalf("recording needs an API key: %v", confErr)
+		}
+
+		cassette, err := NewCassetteRecorder(path, nil)
+
+		if err != nil {
+			t.Fatalf("error creating cassette: %v", err)
+		}
+
+		t.Cleanup(func() { cassette.Close() })
+
+		return NewClient(conf.GptKey, WithOrganization(conf.GptOrgId), WithProject(conf.GptProject), WithTransport(cassette))
+	}
+
+	if _, err := os.Stat(path); err == nil {
+
+		cassette, err := NewCassetteReplayer(path)
+
+		if err != nil {
+			t.Fatalf("error loading cassette: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if n := cassette.Remaining(); n > 0 && !t.Failed() {
+				t.Errorf("%d recorded requests were never made", n)
+			}
+		})
+
+		return NewClient("test-key", WithTransport(cassette))
+	}
+
+	if confErr != nil {
+		t.Skipf("no cassette at %s and no API key: %v", path, confErr)
+	}
+
+	return NewClient(conf.GptKey, WithOrganization(conf.GptOrgId), WithProject(conf.GptProject))
+}
+
+// Simple helper function to build a test query.
+func buildTestQueryHelper(t *testing.T) *GoGPTQuery {
+
+	gpt := testClient(t).NewQuery()
 	gpt.MaxTokens = 100
 
-	return gpt, nil
+	return gpt
 }
 
 // This is the simplest test - just a round-trip to the API.
 func TestGenerate(t *testing.T) {
 
-	gpt, err := buildTestQueryHelper()
-
-	if err != nil {
-		t.Errorf("Error building test query: %v", err)
-	}
+	gpt := buildTestQueryHelper(t)
 
 	t.Logf("Query: %+v", gpt)
 
@@ -78,17 +166,12 @@ func TestGenerateWithFunctions(t *testing.T) {
 		Distance  string `json:"distance"`
 	}
 
-	gpt, err := buildTestQueryHelper()
-
-	if err != nil {
-		t.Errorf("Error building test query: %v", err)
-		return
-	}
+	gpt := buildTestQueryHelper(t)
 
 	gpt.AddMessage(ROLE_SYSTEM, "", "Interpret user input as game commands. If the user wants to do something, call the appropriate function.")
 	gpt.AddMessage(ROLE_USER, "", "Walk forward three steps.")
 
-	gpt.AddFunction("get_game_instruction_from_user_input", "Get game instruction from user input", Event{})
+	_, err := gpt.AddFunction("get_game_instruction_from_user_input", "Get game instruction from user input", Event{})
 
 	if err != nil {
 		t.Errorf("Error building test query: %v", err)
@@ -128,3 +211,36 @@ func TestGenerateWithFunctions(t *testing.T) {
 		return
 	}
 }
+
+func TestGenerateWithContextCancelled(t *testing.T) {
+
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := gpt.GenerateWithContext(ctx)
+
+	if err == nil {
+		t.Fatalf("expected an error from a cancelled context")
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("request was not aborted promptly: %v", time.Since(start))
+	}
+}
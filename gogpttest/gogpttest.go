@@ -0,0 +1,725 @@
+/*
+Package gogpttest runs a fake OpenAI server for testing code built on gogpt without an API key or
+network access. It answers chat completions, streamed or not, and embeddings, records every
+request it receives, and can be scripted with canned replies, function and tool calls, errors
+and latency.
+
+	server := gogpttest.NewServer()
+	defer server.Close()
+
+	server.QueueChat(gogpttest.RateLimited(), gogpttest.Text("Pigs can't fly."))
+
+	q := gogpt.NewGoGPTQuery("test-key")
+	q.Endpoint = server.ChatEndpoint()
+	resp, err := q.AddMessage(gogpt.ROLE_USER, "", "Can pigs fly?").Generate()
+
+	server.AssertCalls(t, 2)
+	server.AssertLastMessage(t, gogpt.ROLE_USER, "pigs")
+
+Chat replies are served from the queue in order, one per request, including retries. Once the
+queue is empty every request gets DefaultReply. Embeddings are derived from a hash of each input,
+so the same text always has the same unit vector. Usage counts words rather than tokens.
+*/
+package gogpttest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dratner/gogpt"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+const (
+	CHAT_PATH          = "/chat/completions"
+	EMBEDDINGS_PATH    = "/embeddings"
+	DEFAULT_REPLY      = "OK"
+	DEFAULT_DIMENSIONS = 16
+)
+
+/*
+	A Reply scripts one response. Content, FunctionCall and ToolCalls make up the assistant message.
+	Status, if 400 or more, sends Error instead. Chunks, if set, are the content deltas of a streamed
+	reply; otherwise the content is streamed a word at a time.
+*/
+
+type Reply struct {
+	Content      string
+	FunctionCall *gogpt.GoGPTFunctionCall
+	ToolCalls    []gogpt.GoGPTToolCall
+	FinishReason string
+	Usage        *gogpt.GoGPTUsage
+	Chunks       []string
+	Status       int
+	Error        *gogpt.GoGPTError
+	Header       http.Header
+	Latency      time.Duration
+}
+
+// Text replies with content.
+func Text(content string) Reply {
+	return Reply{Content: content}
+}
+
+// FunctionCall replies with a legacy function call.
+func FunctionCall(name string, arguments string) Reply {
+	return Reply{FunctionCall: &gogpt.GoGPTFunctionCall{Name: name, Arguments: arguments}}
+}
+
+// ToolCalls replies with one tool call per function call, numbered call_1, call_2 and so on.
+func ToolCalls(calls ...gogpt.GoGPTFunctionCall) Reply {
+
+	r := Reply{}
+
+	for i, c := range calls {
+		r.ToolCalls = append(r.ToolCalls, gogpt.GoGPTToolCall{
+			Id:       fmt.Sprintf("call_%d", i+1),
+			Type:     gogpt.TOOL_TYPE_FUNCTION,
+			Function: c,
+		})
+	}
+
+	return r
+}
+
+// Error replies with an API error.
+func Error(status int, errType string, code string, message string) Reply {
+	return Reply{
+		Status: status,
+		Error:  &gogpt.GoGPTError{Message: message, ErrType: errType, Code: code},
+	}
+}
+
+// RateLimited replies with a 429 that asks to be retried immediately.
+func RateLimited() Reply {
+
+	r := Error(http.StatusTooManyRequests, "requests", "rate_limit_exceeded", "Rate limit reached")
+	r.Header = http.Header{"Retry-After": {"0"}}
+
+	return r
+}
+
+// ServerError replies with a 500 that asks to be retried immediately.
+func ServerError() Reply {
+
+	r := Error(http.StatusInternalServerError, "server_error", "", "The server had an error while processing your request.")
+	r.Header = http.Header{"Retry-After": {"0"}}
+
+	return r
+}
+
+// A Request is a request received by the server. Chat or Embeddings holds its decoded body.
+type Request struct {
+	Method     string
+	Path       string
+	Header     http.Header
+	Body       []byte
+	Chat       *gogpt.GoGPTQuery
+	Embeddings *gogpt.GoGPTEmbeddingsRequest
+	Time       time.Time
+}
+
+// LastMessage returns the last message of a chat request.
+func (r Request) LastMessage() (gogpt.GoGPTMessage, bool) {
+
+	if r.Chat == nil || len(r.Chat.Messages) == 0 {
+		return gogpt.GoGPTMessage{}, false
+	}
+
+	return r.Chat.Messages[len(r.Chat.Messages)-1], true
+}
+
+/*
+	Set Key to reject requests without that bearer token, Latency to delay every response, and
+	Dimensions to change the length of embeddings when a request doesn't ask for one. The fields
+	can be set directly before the first request; once requests are in flight, use the setters.
+*/
+
+type Server struct {
+	*httptest.Server
+	Key          string
+	Latency      time.Duration
+	Dimensions   int
+	DefaultReply Reply
+	mu           sync.Mutex
+	chat         []Reply
+	embeddings   []Reply
+	requests     []Request
+	ids          int
+}
+
+func NewServer() *Server {
+
+	s := &Server{
+		Dimensions:   DEFAULT_DIMENSIONS,
+		DefaultReply: Text(DEFAULT_REPLY),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// SetKey changes the bearer token requests must carry, or accepts any when key is empty.
+func (s *Server) SetKey(key string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Key = key
+}
+
+// SetLatency changes the delay before every response.
+func (s *Server) SetLatency(d time.Duration) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Latency = d
+}
+
+// SetDimensions changes the length of embeddings when a request doesn't ask for one.
+func (s *Server) SetDimensions(dims int) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Dimensions = dims
+}
+
+// SetDefaultReply changes the reply to chat requests once the queue is empty.
+func (s *Server) SetDefaultReply(reply Reply) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.DefaultReply = reply
+}
+
+// ChatEndpoint is the URL to put in GoGPTQuery.Endpoint.
+func (s *Server) ChatEndpoint() string {
+	return s.URL + CHAT_PATH
+}
+
+// EmbeddingsEndpoint is the URL to put in GoGPTEmbeddingsQuery.Endpoint.
+func (s *Server) EmbeddingsEndpoint() string {
+	return s.URL + EMBEDDINGS_PATH
+}
+
+// Client returns a gogpt client pointed at the server.
+func (s *Server) Client(opts ...gogpt.ClientOption) *gogpt.Client {
+
+	s.mu.Lock()
+	key := s.Key
+	s.mu.Unlock()
+
+	if key == "" {
+		key = "test-key"
+	}
+
+	return gogpt.NewClient(key, append([]gogpt.ClientOption{gogpt.WithBaseURL(s.URL)}, opts...)...)
+}
+
+// QueueChat adds replies for the next chat requests.
+func (s *Server) QueueChat(replies ...Reply) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chat = append(s.chat, replies...)
+}
+
+// QueueEmbeddings adds replies for the next embeddings requests. Only errors and latency are used; other replies embed as usual.
+func (s *Server) QueueEmbeddings(replies ...Reply) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.embeddings = append(s.embeddings, replies...)
+}
+
+// Pending returns how many queued replies have not been served.
+func (s *Server) Pending() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.chat) + len(s.embeddings)
+}
+
+// Requests returns every request received, oldest first.
+func (s *Server) Requests() []Request {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// LastRequest returns the most recent request.
+func (s *Server) LastRequest() (Request, bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.requests) == 0 {
+		return Request{}, false
+	}
+
+	return s.requests[len(s.requests)-1], true
+}
+
+// Reset forgets queued replies and received requests.
+func (s *Server) Reset() {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chat = nil
+	s.embeddings = nil
+	s.requests = nil
+}
+
+/*
+	Assertions
+*/
+
+// AssertCalls fails the test unless the server received exactly n requests.
+func (s *Server) AssertCalls(t testing.TB, n int) {
+
+	t.Helper()
+
+	if got := len(s.Requests()); got != n {
+		t.Errorf("expected %d requests, got %d", n, got)
+	}
+}
+
+// AssertLastMessage fails the test unless the last chat request ended with a message of role containing text.
+func (s *Server) AssertLastMessage(t testing.TB, role string, text string) {
+
+	t.Helper()
+
+	r, ok := s.LastRequest()
+
+	if !ok {
+		t.Errorf("expected a request, got none")
+		return
+	}
+
+	msg, ok := r.LastMessage()
+
+	if !ok {
+		t.Errorf("expected a chat request with messages, got %s %s", r.Method, r.Path)
+		return
+	}
+
+	if msg.Role != role || !strings.Contains(msg.Content, text) {
+		t.Errorf("expected the last message to be %s containing %q, got %s: %q", role, text, msg.Role, msg.Content)
+	}
+}
+
+// AssertDrained fails the test if any queued replies were not served.
+func (s *Server) AssertDrained(t testing.TB) {
+
+	t.Helper()
+
+	if n := s.Pending(); n > 0 {
+		t.Errorf("%d queued replies were not served", n)
+	}
+}
+
+/*
+	Handlers
+*/
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(r.Body)
+
+	if err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request_error", "", err.Error()))
+		return
+	}
+
+	req := Request{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body, Time: time.Now()}
+
+	isChat := strings.HasSuffix(r.URL.Path, CHAT_PATH)
+	isEmbeddings := strings.HasSuffix(r.URL.Path, EMBEDDINGS_PATH)
+
+	if isChat {
+		req.Chat = new(gogpt.GoGPTQuery)
+		err = json.Unmarshal(body, req.Chat)
+	}
+
+	if isEmbeddings {
+		req.Embeddings = new(gogpt.GoGPTEmbeddingsRequest)
+		err = json.Unmarshal(body, req.Embeddings)
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.ids++
+	id := s.ids
+	key, latency, dims, fallback := s.Key, s.Latency, s.Dimensions, s.DefaultReply
+	s.mu.Unlock()
+
+	if !s.wait(r, latency) {
+		return
+	}
+
+	switch {
+	case !isChat && !isEmbeddings:
+		writeError(w, Error(http.StatusNotFound, "invalid_request_error", "unknown_url", "Unknown request URL: "+r.Method+" "+r.URL.Path))
+	case r.Method != http.MethodPost:
+		writeError(w, Error(http.StatusMethodNotAllowed, "invalid_request_error", "", "Only POST is supported"))
+	case key != "" && r.Header.Get("Authorization") != "Bearer "+key:
+		writeError(w, Error(http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Incorrect API key provided"))
+	case err != nil:
+		writeError(w, Error(http.StatusBadRequest, "invalid_request_error", "", "Could not parse the JSON body: "+err.Error()))
+	case isChat:
+		s.serveChat(w, r, req.Chat, id, fallback)
+	default:
+		s.serveEmbeddings(w, r, req.Embeddings, dims)
+	}
+}
+
+// wait sleeps for d, returning false if the client gave up first.
+func (s *Server) wait(r *http.Request, d time.Duration) bool {
+
+	if d <= 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (s *Server) next(queue *[]Reply, fallback Reply) Reply {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(*queue) == 0 {
+		return fallback
+	}
+
+	r := (*queue)[0]
+	*queue = (*queue)[1:]
+
+	return r
+}
+
+func writeError(w http.ResponseWriter, reply Reply) {
+
+	for k, v := range reply.Header {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.Status)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": reply.Error})
+}
+
+func words(s string) int {
+	return len(strings.Fields(s))
+}
+
+func (s *Server) serveChat(w http.ResponseWriter, r *http.Request, q *gogpt.GoGPTQuery, id int, fallback Reply) {
+
+	reply := s.next(&s.chat, fallback)
+
+	if !s.wait(r, reply.Latency) {
+		return
+	}
+
+	if reply.Status >= 400 {
+		writeError(w, reply)
+		return
+	}
+
+	for k, v := range reply.Header {
+		w.Header()[k] = v
+	}
+
+	msg := gogpt.GoGPTMessage{
+		Role:         gogpt.ROLE_ASSISTANT,
+		Content:      reply.Content,
+		FunctionCall: reply.FunctionCall,
+		ToolCalls:    reply.ToolCalls,
+	}
+
+	finish := reply.FinishReason
+
+	if finish == "" {
+		switch {
+		case len(reply.ToolCalls) > 0:
+			finish = "tool_calls"
+		case reply.FunctionCall != nil:
+			finish = "function_call"
+		default:
+			finish = "stop"
+		}
+	}
+
+	usage := gogpt.GoGPTUsage{CompletionTokens: words(reply.Content)}
+
+	if reply.Usage != nil {
+		usage = *reply.Usage
+	} else {
+		for _, m := range q.Messages {
+			usage.PromptTokens += words(m.Content)
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	resp := gogpt.GoGPTResponse{
+		Id:      fmt.Sprintf("chatcmpl-test-%d", id),
+		Object:  "chat.completion",
+		Created: int32(time.Now().Unix()),
+		Model:   q.Model,
+		Usage:   usage,
+	}
+
+	if !q.Stream {
+		resp.Choices = []gogpt.GoGPTChoice{{Index: 0, Message: msg, FinishReason: finish}}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	chunks := reply.Chunks
+
+	if chunks == nil {
+		chunks = split(reply.Content)
+	}
+
+	deltas := []gogpt.GoGPTDelta{{Role: gogpt.ROLE_ASSISTANT}}
+
+	for _, c := range chunks {
+		deltas = append(deltas, gogpt.GoGPTDelta{Content: c})
+	}
+
+	if reply.FunctionCall != nil {
+		deltas = append(deltas,
+			gogpt.GoGPTDelta{FunctionCall: &gogpt.GoGPTFunctionCall{Name: reply.FunctionCall.Name}},
+			gogpt.GoGPTDelta{FunctionCall: &gogpt.GoGPTFunctionCall{Arguments: reply.FunctionCall.Arguments}})
+	}
+
+	for i, tc := range reply.ToolCalls {
+		deltas = append(deltas,
+			gogpt.GoGPTDelta{ToolCalls: []gogpt.GoGPTToolCallDelta{{Index: i, Id: tc.Id, Type: tc.Type, Function: gogpt.GoGPTFunctionCall{Name: tc.Function.Name}}}},
+			gogpt.GoGPTDelta{ToolCalls: []gogpt.GoGPTToolCallDelta{{Index: i, Function: gogpt.GoGPTFunctionCall{Arguments: tc.Function.Arguments}}}})
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+
+	event := func(chunk gogpt.GoGPTStreamChunk) {
+		chunk.Id, chunk.Object, chunk.Created, chunk.Model = resp.Id, "chat.completion.chunk", resp.Created, resp.Model
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	for _, d := range deltas {
+		event(gogpt.GoGPTStreamChunk{Choices: []gogpt.GoGPTStreamChoice{{Delta: d}}})
+	}
+
+	event(gogpt.GoGPTStreamChunk{Choices: []gogpt.GoGPTStreamChoice{{FinishReason: finish}}})
+
+	if q.StreamOptions != nil && q.StreamOptions.IncludeUsage {
+		event(gogpt.GoGPTStreamChunk{Choices: []gogpt.GoGPTStreamChoice{}, Usage: &usage})
+	}
+
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// split breaks text into words, each keeping the spaces after it.
+func split(text string) []string {
+
+	var chunks []string
+
+	for _, w := range strings.SplitAfter(text, " ") {
+		if w != "" {
+			chunks = append(chunks, w)
+		}
+	}
+
+	return chunks
+}
+
+func (s *Server) serveEmbeddings(w http.ResponseWriter, r *http.Request, e *gogpt.GoGPTEmbeddingsRequest, dims int) {
+
+	reply := s.next(&s.embeddings, Reply{})
+
+	if !s.wait(r, reply.Latency) {
+		return
+	}
+
+	if reply.Status >= 400 {
+		writeError(w, reply)
+		return
+	}
+
+	inputs, err := texts(e.Input)
+
+	if err != nil {
+		writeError(w, Error(http.StatusBadRequest, "invalid_request_error", "", err.Error()))
+		return
+	}
+
+	if e.Dimensions > 0 {
+		dims = e.Dimensions
+	}
+
+	if dims <= 0 {
+		dims = DEFAULT_DIMENSIONS
+	}
+
+	type item struct {
+		Object    string      `json:"object"`
+		Index     int         `json:"index"`
+		Embedding interface{} `json:"embedding"`
+	}
+
+	resp := struct {
+		Object string           `json:"object"`
+		Model  string           `json:"model"`
+		Data   []item           `json:"data"`
+		Usage  gogpt.GoGPTUsage `json:"usage"`
+	}{Object: "list", Model: e.Model, Data: []item{}}
+
+	for i, text := range inputs {
+
+		var vector interface{} = Embedding(text, dims)
+
+		if e.EncodingFormat == gogpt.ENCODING_FORMAT_BASE64 {
+			vector = encode(Embedding(text, dims))
+		}
+
+		resp.Data = append(resp.Data, item{Object: "embedding", Index: i, Embedding: vector})
+		resp.Usage.PromptTokens += words(text)
+	}
+
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens
+
+	for k, v := range reply.Header {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// texts flattens an embeddings input of strings or token arrays into one string per input.
+func texts(input interface{}) ([]string, error) {
+
+	switch v := input.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			switch t := item.(type) {
+			case string:
+				out = append(out, t)
+			case []interface{}:
+				out = append(out, fmt.Sprint(t...))
+			default:
+				return nil, fmt.Errorf("unsupported input %v", item)
+			}
+		}
+		if len(out) == 0 {
+			return nil, fmt.Errorf("input is empty")
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("unsupported input %v", input)
+}
+
+// Embedding returns the unit vector the server gives text, so tests can compute expected results.
+func Embedding(text string, dims int) []float64 {
+
+	v := make([]float64, dims)
+	norm := 0.0
+
+	for i := range v {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d:%s", i, text)
+		v[i] = float64(h.Sum64()%2001)/1000 - 1
+		norm += v[i] * v[i]
+	}
+
+	norm = math.Sqrt(norm)
+
+	for i := range v {
+		if norm > 0 {
+			v[i] /= norm
+		}
+	}
+
+	return v
+}
+
+// encode packs a vector as base64 little-endian float32s, like the API does.
+func encode(v []float64) string {
+
+	var b bytes.Buffer
+
+	for _, f := range v {
+		binary.Write(&b, binary.LittleEndian, float32(f))
+	}
+
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+/*
+	Tokenizer
+*/
+
+// byteBpeLoader is a stand-in vocabulary of single bytes, used when the real encodings can't be downloaded.
+type byteBpeLoader struct{}
+
+func (byteBpeLoader) LoadTiktokenBpe(file string) (map[string]int, error) {
+
+	ranks := map[string]int{}
+
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+
+	return ranks, nil
+}
+
+/*
+	UseOfflineTokenizer lets token counting work without network access. When the real encodings
+	can't be loaded, every byte becomes a token, so counts are only approximate but budgeting code
+	can still be exercised. Call it from TestMain before the tests run.
+*/
+
+func UseOfflineTokenizer() {
+
+	if _, err := tiktoken.GetEncoding(gogpt.ENCODING_CL100K); err != nil {
+		tiktoken.SetBpeLoader(byteBpeLoader{})
+	}
+}
@@ -0,0 +1,276 @@
+package gogpttest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dratner/gogpt"
+)
+
+func query(s *Server, question string) *gogpt.GoGPTQuery {
+
+	q := gogpt.NewGoGPTQuery("test-key")
+	q.Endpoint = s.ChatEndpoint()
+	q.Retry = &gogpt.RetryPolicy{MaxAttempts: 1}
+
+	return q.AddMessage(gogpt.ROLE_USER, "", question)
+}
+
+func TestChat(t *testing.T) {
+
+	s := NewServer()
+	defer s.Close()
+
+	s.QueueChat(Text("Pigs can't fly."))
+
+	resp, err := query(s, "Can pigs fly?").Generate()
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if resp.Choices[0].Message.Content != "Pigs can't fly." || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected reply: %+v", resp.Choices[0])
+	}
+
+	if resp.Usage.PromptTokens != 3 || resp.Usage.CompletionTokens != 3 || resp.Usage.TotalTokens != 6 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+
+	// The queue is empty, so the default reply follows.
+	resp, err = query(s, "And cows?").Generate()
+
+	if err != nil || resp.Choices[0].Message.Content != DEFAULT_REPLY {
+		t.Errorf("expected the default reply, got %+v %v", resp, err)
+	}
+
+	s.AssertCalls(t, 2)
+	s.AssertLastMessage(t, gogpt.ROLE_USER, "cows")
+	s.AssertDrained(t)
+
+	r, _ := s.LastRequest()
+
+	if r.Header.Get("Authorization") != "Bearer test-key" || r.Chat.Model != gogpt.MODEL_35_TURBO {
+		t.Errorf("unexpected request: %+v", r)
+	}
+}
+
+func TestToolCalls(t *testing.T) {
+
+	s := NewServer()
+	defer s.Close()
+
+	s.QueueChat(
+		ToolCalls(gogpt.GoGPTFunctionCall{Name: "weather", Arguments: `{"city":"Paris"}`}),
+		FunctionCall("weather", `{"city":"Rome"}`),
+	)
+
+	resp, err := query(s, "Weather in Paris?").Generate()
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	calls := resp.Choices[0].Message.ToolCalls
+
+	if len(calls) != 1 || calls[0].Id != "call_1" || calls[0].Function.Arguments != `{"city":"Paris"}` || resp.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("unexpected tool calls: %+v", resp.Choices[0])
+	}
+
+	resp, err = query(s, "Weather in Rome?").Generate()
+
+	if err != nil || resp.Choices[0].Message.FunctionCall == nil || resp.Choices[0].Message.FunctionCall.Name != "weather" {
+		t.Errorf("unexpected function call: %+v %v", resp, err)
+	}
+}
+
+func TestStream(t *testing.T) {
+
+	s := NewServer()
+	defer s.Close()
+
+	s.QueueChat(Text("Pigs can't fly."), Reply{
+		Chunks:    []string{},
+		ToolCalls: ToolCalls(gogpt.GoGPTFunctionCall{Name: "weather", Arguments: `{"city":"Paris"}`}).ToolCalls,
+	})
+
+	stream, err := query(s, "Can pigs fly?").GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error streaming: %v", err)
+	}
+	defer stream.Close()
+
+	var deltas []string
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error receiving: %v", err)
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				deltas = append(deltas, c.Delta.Content)
+			}
+		}
+	}
+
+	if len(deltas) != 3 || deltas[0] != "Pigs " {
+		t.Errorf("unexpected deltas: %q", deltas)
+	}
+
+	resp := stream.Response()
+
+	if resp.Choices[0].Message.Content != "Pigs can't fly." || resp.Usage.TotalTokens != 6 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	stream, err = query(s, "Weather in Paris?").GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error streaming: %v", err)
+	}
+	defer stream.Close()
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			break
+		}
+	}
+
+	calls := stream.Response().Choices[0].Message.ToolCalls
+
+	if len(calls) != 1 || calls[0].Function.Name != "weather" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool calls: %+v", calls)
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	s := NewServer()
+	defer s.Close()
+
+	s.QueueChat(RateLimited(), ServerError(), Text("Finally."))
+
+	q := query(s, "Can pigs fly?")
+	q.Retry = gogpt.DefaultRetryPolicy()
+
+	resp, err := q.Generate()
+
+	if err != nil || resp.Choices[0].Message.Content != "Finally." {
+		t.Fatalf("expected retries to succeed, got %+v %v", resp, err)
+	}
+
+	s.AssertCalls(t, 3)
+
+	s.QueueChat(RateLimited())
+
+	if _, err := query(s, "Can pigs fly?").Generate(); !errors.Is(err, gogpt.ErrRateLimited) {
+		t.Errorf("expected a rate limit error, got %v", err)
+	}
+
+	s.QueueChat(Error(http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", "Too long"))
+
+	if _, err := query(s, "Can pigs fly?").Generate(); !errors.Is(err, gogpt.ErrContextLengthExceeded) {
+		t.Errorf("expected a context length error, got %v", err)
+	}
+
+	s.SetKey("right-key")
+
+	if _, err := query(s, "Can pigs fly?").Generate(); !errors.Is(err, gogpt.ErrInvalidAPIKey) {
+		t.Errorf("expected an invalid key error, got %v", err)
+	}
+}
+
+func TestLatency(t *testing.T) {
+
+	s := NewServer()
+	defer s.Close()
+
+	s.QueueChat(Reply{Content: "Slow.", Latency: 200 * time.Millisecond})
+
+	q := query(s, "Can pigs fly?")
+	q.Timeout = 20 * time.Millisecond
+
+	if _, err := q.Generate(); err == nil {
+		t.Errorf("expected a timeout")
+	}
+
+	// The first handler may still be waiting out its reply's latency.
+	s.SetLatency(10 * time.Millisecond)
+
+	start := time.Now()
+
+	if _, err := query(s, "Can pigs fly?").Generate(); err != nil || time.Since(start) < 10*time.Millisecond {
+		t.Errorf("expected a delayed reply, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+
+	s := NewServer()
+	defer s.Close()
+
+	e := s.Client().NewEmbeddingsQuery()
+	e.Input = []string{"pigs", "cows", "pigs"}
+
+	resp, err := e.Generate()
+
+	if err != nil {
+		t.Fatalf("error embedding: %v", err)
+	}
+
+	if len(resp.Data) != 3 || len(resp.Data[0].Embedding) != DEFAULT_DIMENSIONS || resp.Usage.PromptTokens != 3 {
+		t.Fatalf("unexpected embeddings: %+v", resp)
+	}
+
+	want := Embedding("pigs", DEFAULT_DIMENSIONS)
+	norm := 0.0
+
+	for i, v := range resp.Data[0].Embedding {
+		if v != want[i] || v != resp.Data[2].Embedding[i] {
+			t.Fatalf("expected deterministic embeddings, got %v and %v", resp.Data[0].Embedding, resp.Data[2].Embedding)
+		}
+		norm += v * v
+	}
+
+	if math.Abs(norm-1) > 1e-9 {
+		t.Errorf("expected a unit vector, got norm %v", norm)
+	}
+
+	e.Dimensions = 4
+	e.EncodingFormat = gogpt.ENCODING_FORMAT_BASE64
+
+	resp, err = e.Generate()
+
+	if err != nil || len(resp.Data[1].Embedding) != 4 || math.Abs(resp.Data[1].Embedding[0]-Embedding("cows", 4)[0]) > 1e-6 {
+		t.Errorf("unexpected base64 embeddings: %+v %v", resp, err)
+	}
+
+	s.QueueEmbeddings(Error(http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Incorrect API key provided"))
+
+	if _, err := e.Generate(); !errors.Is(err, gogpt.ErrInvalidAPIKey) {
+		t.Errorf("expected an invalid key error, got %v", err)
+	}
+
+	if r, _ := s.LastRequest(); r.Embeddings == nil || r.Embeddings.Model != gogpt.MODEL_EMBEDDING_3_SMALL {
+		t.Errorf("unexpected request: %+v", r)
+	}
+}
+
+func TestUseOfflineTokenizer(t *testing.T) {
+
+	UseOfflineTokenizer()
+
+	if n, err := gogpt.CountTokens("Can pigs fly?", gogpt.MODEL_4o_MINI); err != nil || n == 0 {
+		t.Errorf("expected tokens to be counted, got %d %v", n, err)
+	}
+}
@@ -0,0 +1,287 @@
+package gogpt
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+	A ChatMemory gives a GoGPTChat long-term recall. Whenever the chat's ContextStrategy drops or
+	summarizes messages away, the memory embeds them. On each Generate it embeds the queued messages,
+	finds the TopK most similar remembered messages, and adds them to that request as a system
+	message of at most MaxTokens tokens, placed just before the queue. The recalled message is not
+	kept in the history, and the chat leaves room for it when deciding whether to compact.
+
+	Embeddings are requested through Embedder, usually a GoGPTEmbeddingsQuery or Client. Remembered
+	messages live in memory only.
+*/
+
+const (
+	MEMORY_TOP_K  = 5
+	MEMORY_TOKENS = 500
+	MEMORY_PROMPT = "Relevant messages from earlier in the conversation:"
+)
+
+type ChatMemory struct {
+	Embedder  Embedder
+	TopK      int
+	MaxTokens int
+	MinScore  float64
+	Prompt    string
+	mu        sync.Mutex
+	entries   []memoryEntry
+}
+
+type memoryEntry struct {
+	message GoGPTMessage
+	vector  []float64
+}
+
+func NewChatMemory(embedder Embedder) *ChatMemory {
+	return &ChatMemory{
+		Embedder:  embedder,
+		TopK:      MEMORY_TOP_K,
+		MaxTokens: MEMORY_TOKENS,
+		Prompt:    MEMORY_PROMPT,
+	}
+}
+
+// SetMemory gives the chat long-term recall of the messages it compacts away.
+func (c *GoGPTChat) SetMemory(m *ChatMemory) *GoGPTChat {
+
+	c.Memory = m
+
+	return c
+}
+
+// Len returns how many messages are remembered.
+func (m *ChatMemory) Len() int {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.entries)
+}
+
+func (m *ChatMemory) embed(ctx context.Context, texts []string) ([][]float64, error) {
+
+	if m.Embedder == nil {
+		return nil, fmt.Errorf("no embedder for memory")
+	}
+
+	resp, err := m.Embedder.Embed(ctx, texts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	vectors := make([][]float64, len(texts))
+
+	for i, d := range resp.Data {
+		vectors[i] = d.Embedding
+	}
+
+	return vectors, nil
+}
+
+// Remember embeds and stores messages. Messages without content, such as bare tool calls, are skipped.
+func (m *ChatMemory) Remember(ctx context.Context, msgs []GoGPTMessage) error {
+
+	var keep []GoGPTMessage
+	var texts []string
+
+	for _, msg := range msgs {
+		if strings.TrimSpace(msg.Content) != "" {
+			keep = append(keep, msg)
+			texts = append(texts, msg.Content)
+		}
+	}
+
+	if len(keep) == 0 {
+		return nil
+	}
+
+	vectors, err := m.embed(ctx, texts)
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, msg := range keep {
+		m.entries = append(m.entries, memoryEntry{message: msg, vector: vectors[i]})
+	}
+
+	return nil
+}
+
+// Recall returns up to TopK remembered messages most similar to text, best first.
+func (m *ChatMemory) Recall(ctx context.Context, text string) ([]GoGPTMessage, error) {
+
+	if m.Len() == 0 || strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	vectors, err := m.embed(ctx, []string{text})
+
+	if err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		message GoGPTMessage
+		score   float64
+	}
+
+	var results []scored
+
+	m.mu.Lock()
+
+	for _, e := range m.entries {
+		if score := cosine(vectors[0], e.vector); score >= m.MinScore {
+			results = append(results, scored{e.message, score})
+		}
+	}
+
+	m.mu.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+
+	k := m.TopK
+
+	if k <= 0 {
+		k = MEMORY_TOP_K
+	}
+
+	if len(results) > k {
+		results = results[:k]
+	}
+
+	msgs := make([]GoGPTMessage, len(results))
+
+	for i, r := range results {
+		msgs[i] = r.message
+	}
+
+	return msgs, nil
+}
+
+// budget is the room the recalled message may take in a request.
+func (m *ChatMemory) budget() int {
+
+	if m.MaxTokens <= 0 {
+		return MEMORY_TOKENS
+	}
+
+	return m.MaxTokens
+}
+
+// message builds a system message of what the queue recalls that fits the token budget, or nil if nothing fits.
+func (m *ChatMemory) message(ctx context.Context, queue []GoGPTMessage, model string) (*GoGPTMessage, error) {
+
+	var texts []string
+
+	for _, msg := range queue {
+		if msg.Content != "" {
+			texts = append(texts, msg.Content)
+		}
+	}
+
+	recalled, err := m.Recall(ctx, strings.Join(texts, "\n"))
+
+	if err != nil || len(recalled) == 0 {
+		return nil, err
+	}
+
+	prompt := m.Prompt
+
+	if prompt == "" {
+		prompt = MEMORY_PROMPT
+	}
+
+	msg := &GoGPTMessage{Role: ROLE_SYSTEM, Content: prompt}
+	added := 0
+
+	for _, r := range recalled {
+
+		candidate := msg.Content + "\n" + strings.TrimSuffix(Transcript([]GoGPTMessage{r}), "\n")
+
+		size, err := CountMessageTokens([]GoGPTMessage{{Role: ROLE_SYSTEM, Content: candidate}}, model)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if size > m.budget() {
+			continue
+		}
+
+		msg.Content = candidate
+		added++
+	}
+
+	if added == 0 {
+		return nil, nil
+	}
+
+	return msg, nil
+}
+
+func cosine(a []float64, b []float64) float64 {
+
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// evicted returns the messages in before that are missing from after.
+func evicted(before []GoGPTMessage, after []GoGPTMessage) []GoGPTMessage {
+
+	key := func(msg GoGPTMessage) string {
+		return msg.Role + "\x00" + msg.Name + "\x00" + msg.ToolCallId + "\x00" + msg.Content
+	}
+
+	kept := map[string]int{}
+
+	for _, msg := range after {
+		kept[key(msg)]++
+	}
+
+	var gone []GoGPTMessage
+
+	for _, msg := range before {
+		if kept[key(msg)] > 0 {
+			kept[key(msg)]--
+			continue
+		}
+		gone = append(gone, msg)
+	}
+
+	return gone
+}
@@ -0,0 +1,118 @@
+package gogpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testMemoryServer embeds text by which topics it mentions and records the chat requests it answers.
+func testMemoryServer(t *testing.T, requests *[]GoGPTQuery) *httptest.Server {
+
+	topics := []string{"pig", "weather", "cow"}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.HasSuffix(r.URL.Path, "/embeddings") {
+
+			var req struct {
+				Input []string `json:"input"`
+			}
+
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("bad embeddings request: %v", err)
+			}
+
+			resp := GoGPTEmbeddings{Object: "list", Model: MODEL_EMBEDDING_3_SMALL}
+
+			for i, input := range req.Input {
+				v := []float64{0.01, 0.01, 0.01}
+				for j, topic := range topics {
+					if strings.Contains(strings.ToLower(input), topic) {
+						v[j] = 1
+					}
+				}
+				resp.Data = append(resp.Data, EmbeddingData{Embedding: v, Index: i, Object: "embedding"})
+			}
+
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		var q GoGPTQuery
+
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Errorf("bad chat request: %v", err)
+		}
+
+		*requests = append(*requests, q)
+
+		fmt.Fprint(w, testReply)
+	}))
+}
+
+func TestChatMemory(t *testing.T) {
+
+	var requests []GoGPTQuery
+
+	server := testMemoryServer(t, &requests)
+	defer server.Close()
+
+	RegisterModel(ModelInfo{Name: "tiny-test-model", ContextWindow: 2000, MaxOutputTokens: 100, PromptPrice: 1, CompletionPrice: 1})
+
+	client := NewClient("test-key", WithBaseURL(server.URL))
+
+	memory := NewChatMemory(client.NewEmbeddingsQuery())
+	memory.TopK = 1
+	memory.MaxTokens = 100
+
+	chat := client.NewChat().SetStrategy(&SlidingWindow{}).SetMemory(memory)
+	chat.Query.Model = "tiny-test-model"
+	chat.Query.MaxTokens = 50
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a farmer.")
+	chat.AddMessage(ROLE_USER, "", "My pig is named Wilbur.")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		chat.AddMessage(ROLE_USER, "", strings.Repeat("Tell me about the weather. ", 40))
+		if _, err := chat.Generate(); err != nil {
+			t.Fatalf("error generating: %v", err)
+		}
+	}
+
+	for _, msg := range chat.Query.Messages {
+		if strings.Contains(msg.Content, "Wilbur") {
+			t.Fatalf("expected the pig to have left the history: %+v", chat.Query.Messages)
+		}
+	}
+
+	if memory.Len() == 0 {
+		t.Fatalf("evicted messages were not remembered")
+	}
+
+	chat.AddMessage(ROLE_USER, "", "What is my pig called?")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	sent := requests[len(requests)-1].Messages
+	recalled := sent[len(sent)-2]
+
+	if recalled.Role != ROLE_SYSTEM || !strings.HasPrefix(recalled.Content, MEMORY_PROMPT) || !strings.Contains(recalled.Content, "Wilbur") || strings.Contains(recalled.Content, "weather") {
+		t.Errorf("expected only the pig to be recalled before the question, got %+v", recalled)
+	}
+
+	// The recalled message is only part of the request.
+	for _, msg := range chat.Query.Messages {
+		if strings.HasPrefix(msg.Content, MEMORY_PROMPT) {
+			t.Errorf("recalled messages were kept in the history")
+		}
+	}
+}
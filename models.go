@@ -0,0 +1,222 @@
+package gogpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+	Model limits and prices change often: https://platform.openai.com/docs/models and https://openai.com/pricing
+
+	The registry starts with an entry for each MODEL_* constant. Fine-tuned, self-hosted or newer
+	models can be added at runtime with RegisterModel, or loaded from a JSON file holding a list of
+	ModelInfo objects:
+
+	[{"name": "ft:gpt-4o-mini:acme::abc123", "context_window": 128000, "max_output_tokens": 16384,
+	  "encoding": "o200k_base", "prompt_price": 0.3, "completion_price": 1.2, "functions": true}]
+
+	Lookups fall back to the longest registered prefix followed by a snapshot date or "latest", so
+	"gpt-4o-2024-08-06" finds "gpt-4o", but "gpt-4-turbo" needs an entry of its own rather than
+	finding "gpt-4".
+*/
+
+const (
+	DEFAULT_CONTEXT_WINDOW = 16385
+)
+
+type ModelInfo struct {
+	Name              string  `json:"name"`
+	ContextWindow     int     `json:"context_window"`
+	MaxOutputTokens   int     `json:"max_output_tokens,omitempty"`
+	Encoding          string  `json:"encoding,omitempty"`
+	PromptPrice       float64 `json:"prompt_price,omitempty"`     // dollars per million prompt tokens
+	CompletionPrice   float64 `json:"completion_price,omitempty"` // dollars per million completion tokens
+	Functions         bool    `json:"functions,omitempty"`
+	Vision            bool    `json:"vision,omitempty"`
+	JSONMode          bool    `json:"json_mode,omitempty"`
+	StructuredOutputs bool    `json:"structured_outputs,omitempty"`
+}
+
+var (
+	modelsMu sync.RWMutex
+	models   = map[string]ModelInfo{}
+)
+
+func init() {
+
+	builtin := []ModelInfo{
+		{Name: "gpt-3.5-turbo", ContextWindow: 16385, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 0.5, CompletionPrice: 1.5, Functions: true, JSONMode: true},
+		{Name: MODEL_35_TURBO, ContextWindow: 16385, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 1, CompletionPrice: 2, Functions: true, JSONMode: true},
+		{Name: MODEL_4, ContextWindow: 8192, MaxOutputTokens: 8192, Encoding: ENCODING_CL100K, PromptPrice: 30, CompletionPrice: 60, Functions: true},
+		{Name: "gpt-4-32k", ContextWindow: 32768, MaxOutputTokens: 32768, Encoding: ENCODING_CL100K, PromptPrice: 60, CompletionPrice: 120, Functions: true},
+		{Name: MODEL_4_TURBO, ContextWindow: 128000, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 10, CompletionPrice: 30, Functions: true, JSONMode: true},
+		{Name: "gpt-4-0125-preview", ContextWindow: 128000, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 10, CompletionPrice: 30, Functions: true, JSONMode: true},
+		{Name: "gpt-4-turbo-preview", ContextWindow: 128000, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 10, CompletionPrice: 30, Functions: true, JSONMode: true},
+		{Name: "gpt-4-vision-preview", ContextWindow: 128000, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 10, CompletionPrice: 30, Vision: true},
+		{Name: "gpt-4-turbo", ContextWindow: 128000, MaxOutputTokens: 4096, Encoding: ENCODING_CL100K, PromptPrice: 10, CompletionPrice: 30, Functions: true, Vision: true, JSONMode: true},
+		{Name: MODEL_4o, ContextWindow: 128000, MaxOutputTokens: 16384, Encoding: ENCODING_O200K, PromptPrice: 2.5, CompletionPrice: 10, Functions: true, Vision: true, JSONMode: true, StructuredOutputs: true},
+		{Name: MODEL_4o_MINI, ContextWindow: 128000, MaxOutputTokens: 16384, Encoding: ENCODING_O200K, PromptPrice: 0.15, CompletionPrice: 0.6, Functions: true, Vision: true, JSONMode: true, StructuredOutputs: true},
+		{Name: MODEL_EMBEDDING_ADA, ContextWindow: 8191, Encoding: ENCODING_CL100K, PromptPrice: 0.1},
+		{Name: MODEL_EMBEDDING_3_SMALL, ContextWindow: 8191, Encoding: ENCODING_CL100K, PromptPrice: 0.02},
+		{Name: MODEL_EMBEDDING_3_LARGE, ContextWindow: 8191, Encoding: ENCODING_CL100K, PromptPrice: 0.13},
+	}
+
+	for _, m := range builtin {
+		RegisterModel(m)
+	}
+}
+
+// RegisterModel adds a model to the registry, replacing any existing entry with the same name.
+func RegisterModel(info ModelInfo) error {
+
+	if info.Name == "" {
+		return fmt.Errorf("model name is required")
+	}
+
+	if info.ContextWindow <= 0 {
+		return fmt.Errorf("model %s needs a context window", info.Name)
+	}
+
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+
+	models[info.Name] = info
+
+	return nil
+}
+
+// LookupModel finds a model by name, falling back to the longest registered prefix of a snapshot.
+func LookupModel(name string) (ModelInfo, bool) {
+
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+
+	if info, ok := models[name]; ok {
+		return info, true
+	}
+
+	var best ModelInfo
+	found := false
+
+	for prefix, info := range models {
+		if strings.HasPrefix(name, prefix+"-") && snapshot(name[len(prefix)+1:]) && len(prefix) > len(best.Name) {
+			best = info
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+// snapshot reports whether a model name suffix names a version of the same model, like "2024-08-06", "0613" or "latest".
+func snapshot(suffix string) bool {
+
+	if suffix == "latest" {
+		return true
+	}
+
+	for _, part := range strings.Split(suffix, "-") {
+
+		if part == "" {
+			return false
+		}
+
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Models lists every registered model sorted by name.
+func Models() []ModelInfo {
+
+	modelsMu.RLock()
+	defer modelsMu.RUnlock()
+
+	list := make([]ModelInfo, 0, len(models))
+
+	for _, info := range models {
+		list = append(list, info)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// LoadModels registers every model in a JSON list read from r.
+func LoadModels(r io.Reader) error {
+
+	var list []ModelInfo
+
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return err
+	}
+
+	for _, info := range list {
+		if err := RegisterModel(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadModelsFile registers every model in a JSON file.
+func LoadModelsFile(path string) error {
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return LoadModels(f)
+}
+
+// modelProblems lists what a query asks of a registered model that the model can't do.
+func (g *GoGPTQuery) modelProblems() []string {
+
+	var problems []string
+
+	info, ok := LookupModel(g.Model)
+
+	if !ok {
+		return nil
+	}
+
+	if info.MaxOutputTokens > 0 && g.MaxTokens > info.MaxOutputTokens {
+		problems = append(problems, fmt.Sprintf("max_tokens %d exceeds the %d output tokens %s supports", g.MaxTokens, info.MaxOutputTokens, g.Model))
+	}
+
+	if !info.Functions && (len(g.Functions) > 0 || len(g.Tools) > 0) {
+		problems = append(problems, fmt.Sprintf("%s does not support functions", g.Model))
+	}
+
+	if g.ResponseFormat != nil {
+		switch g.ResponseFormat.Type {
+		case RESPONSE_FORMAT_JSON_OBJECT:
+			if !info.JSONMode {
+				problems = append(problems, fmt.Sprintf("%s does not support JSON mode", g.Model))
+			}
+		case RESPONSE_FORMAT_JSON_SCHEMA:
+			if !info.StructuredOutputs {
+				problems = append(problems, fmt.Sprintf("%s does not support structured outputs", g.Model))
+			}
+		}
+	}
+
+	return problems
+}
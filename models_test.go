@@ -0,0 +1,88 @@
+package gogpt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupModel(t *testing.T) {
+
+	info, ok := LookupModel("gpt-4o-mini-2024-07-18")
+
+	if !ok || info.Name != MODEL_4o_MINI {
+		t.Errorf("expected the longest prefix to win, got %+v", info)
+	}
+
+	if _, ok := LookupModel("gpt-4oo"); ok {
+		t.Errorf("prefix match should stop at a dash")
+	}
+
+	lookups := map[string]string{
+		"gpt-4-0613":             MODEL_4,
+		"gpt-4-turbo":            "gpt-4-turbo",
+		"gpt-4-turbo-2024-04-09": "gpt-4-turbo",
+		"gpt-4-turbo-preview":    "gpt-4-turbo-preview",
+		"gpt-4-0125-preview":     "gpt-4-0125-preview",
+		"gpt-4-32k":              "gpt-4-32k",
+		"gpt-4-32k-0613":         "gpt-4-32k",
+		"gpt-4o-2024-08-06":      MODEL_4o,
+		"gpt-3.5-turbo-0125":     "gpt-3.5-turbo",
+	}
+
+	for name, want := range lookups {
+		if info, ok := LookupModel(name); !ok || info.Name != want {
+			t.Errorf("expected %s to find %s, got %+v", name, want, info)
+		}
+	}
+
+	// A suffix that isn't a snapshot names a different model, not a version of gpt-4.
+	if info, ok := LookupModel("gpt-4-experimental"); ok {
+		t.Errorf("expected no match, got %+v", info)
+	}
+
+	if info, _ := LookupModel("gpt-4-turbo-2024-04-09"); info.ContextWindow != 128000 || info.PromptPrice != 10 {
+		t.Errorf("unexpected gpt-4-turbo entry: %+v", info)
+	}
+
+	if MaxQueryTokens(MODEL_4) != 8192 || MaxQueryTokens("unknown-model") != DEFAULT_CONTEXT_WINDOW {
+		t.Errorf("unexpected context windows")
+	}
+}
+
+func TestLoadModels(t *testing.T) {
+
+	data := `[{"name": "llama-3-70b-local", "context_window": 8192, "max_output_tokens": 2048, "encoding": "cl100k_base", "functions": false}]`
+
+	if err := LoadModels(strings.NewReader(data)); err != nil {
+		t.Fatalf("error loading models: %v", err)
+	}
+
+	if MaxQueryTokens("llama-3-70b-local") != 8192 {
+		t.Errorf("custom model not registered")
+	}
+
+	if err := LoadModels(strings.NewReader(`[{"name": "broken"}]`)); err == nil {
+		t.Errorf("expected an error for a model without a context window")
+	}
+
+	q := NewGoGPTQuery("test-key")
+	q.Model = "llama-3-70b-local"
+	q.AddMessage(ROLE_USER, "", "Hi")
+
+	if err := q.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	q.MaxTokens = 4096
+
+	if err := q.Validate(); err == nil {
+		t.Errorf("expected an error for max_tokens above the output limit")
+	}
+
+	q.MaxTokens = 100
+	q.AddTool("get_weather", "Get the weather for a city", testWeather{})
+
+	if _, err := q.request(); err == nil {
+		t.Errorf("expected an error for tools on a model without function support")
+	}
+}
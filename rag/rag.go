@@ -0,0 +1,406 @@
+/*
+Package rag answers questions from a collection of documents with retrieval-augmented generation.
+
+Documents are split into overlapping chunks by token count with the embedding model's tiktoken
+encoder, embedded in batches, and indexed in a vectorstore.Index. A question is embedded the same
+way, the most similar chunks are retrieved, and as many as fit in ContextTokens are given to the
+model as numbered sources alongside the question. The answer comes back with the sources it was
+given and the ones it cited, each pointing at a chunk ID and its byte offsets in the document.
+
+	p := rag.New(client.NewQuery(), client.NewEmbeddingsQuery())
+	err := p.Add(ctx, rag.Document{Id: "handbook", Text: handbook})
+
+	answer, err := p.Ask(ctx, "How many vacation days do I get?")
+	for _, c := range answer.Citations {
+		fmt.Println(c.Id, c.Start, c.End)
+	}
+*/
+package rag
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/dratner/gogpt"
+	"github.com/dratner/gogpt/vectorstore"
+)
+
+const (
+	DEFAULT_CHUNK_TOKENS   = 400
+	DEFAULT_CHUNK_OVERLAP  = 50
+	DEFAULT_TOP_K          = 4
+	DEFAULT_CONTEXT_TOKENS = 3000
+	RAG_PROMPT             = "Answer the question using only the sources below. Cite each source you use by its id in square brackets, like [handbook#0]. If the sources don't contain the answer, say so."
+	DOCUMENT_KEY           = "document"
+)
+
+var citation = regexp.MustCompile(`\[([^\[\]]+)\]`)
+
+type Document struct {
+	Id       string
+	Text     string
+	Metadata map[string]string
+}
+
+// A Chunk is a piece of a document. Start and End are byte offsets into the document's Text.
+type Chunk struct {
+	Id         string
+	DocumentId string
+	Index      int
+	Text       string
+	Start      int
+	End        int
+	Tokens     int
+	Metadata   map[string]string
+}
+
+type Source struct {
+	Chunk
+	Score float64
+}
+
+type Answer struct {
+	Text      string
+	Sources   []Source
+	Citations []Source
+	Response  *gogpt.GoGPTResponse
+}
+
+/*
+	Only Query and Embedder are required. Each question is asked with a copy of Query, so set its
+	model, credentials and client as for any query; its messages are ignored. Set Completer to send
+	questions through something other than Query itself. Documents are split with the tokenizer of
+	the Embedder's model when it is a GoGPTEmbeddingsQuery.
+*/
+
+type Pipeline struct {
+	Query         *gogpt.GoGPTQuery
+	Completer     gogpt.Completer
+	Embedder      gogpt.Embedder
+	Index         *vectorstore.Index
+	ChunkTokens   int
+	ChunkOverlap  int
+	TopK          int
+	ContextTokens int
+	Prompt        string
+	mu            sync.RWMutex
+	chunks        map[string]Chunk
+	documents     map[string][]string
+}
+
+func New(query *gogpt.GoGPTQuery, embedder gogpt.Embedder) *Pipeline {
+	return &Pipeline{
+		Query:         query,
+		Embedder:      embedder,
+		Index:         vectorstore.New(vectorstore.METRIC_COSINE),
+		ChunkTokens:   DEFAULT_CHUNK_TOKENS,
+		ChunkOverlap:  DEFAULT_CHUNK_OVERLAP,
+		TopK:          DEFAULT_TOP_K,
+		ContextTokens: DEFAULT_CONTEXT_TOKENS,
+		Prompt:        RAG_PROMPT,
+		chunks:        map[string]Chunk{},
+		documents:     map[string][]string{},
+	}
+}
+
+// ChunkText splits text into chunks of at most size tokens, each overlapping the last by overlap tokens.
+func ChunkText(text string, model string, size int, overlap int) ([]Chunk, error) {
+
+	if size <= 0 {
+		return nil, fmt.Errorf("chunk size must be positive")
+	}
+
+	if overlap < 0 || overlap >= size {
+		return nil, fmt.Errorf("chunk overlap must be less than the chunk size")
+	}
+
+	tkm, err := gogpt.TokenEncoder(model)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := tkm.Encode(text, nil, nil)
+
+	// offsets[i] is where token i starts in text.
+	offsets := make([]int, len(tokens)+1)
+
+	for i, tok := range tokens {
+		offsets[i+1] = offsets[i] + len(tkm.Decode([]int{tok}))
+	}
+
+	var chunks []Chunk
+
+	for start := 0; start < len(tokens); start += size - overlap {
+
+		end := start + size
+
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+
+		// Tokens can split a character, so widen to whole characters.
+		from, to := offsets[start], offsets[end]
+
+		for from > 0 && !utf8.RuneStart(text[from]) {
+			from--
+		}
+
+		for to < len(text) && !utf8.RuneStart(text[to]) {
+			to++
+		}
+
+		chunks = append(chunks, Chunk{
+			Index:  len(chunks),
+			Text:   text[from:to],
+			Start:  from,
+			End:    to,
+			Tokens: end - start,
+		})
+
+		if end == len(tokens) {
+			break
+		}
+	}
+
+	return chunks, nil
+}
+
+// chunkModel is the model whose tokenizer splits documents.
+func (p *Pipeline) chunkModel() string {
+
+	if e, ok := p.Embedder.(*gogpt.GoGPTEmbeddingsQuery); ok && e.Model != "" {
+		return e.Model
+	}
+
+	return gogpt.MODEL_EMBEDDING_3_SMALL
+}
+
+// Add chunks, embeds and indexes documents. Adding a document again replaces its chunks.
+func (p *Pipeline) Add(ctx context.Context, docs ...Document) error {
+
+	var chunks []Chunk
+
+	for _, doc := range docs {
+
+		if doc.Id == "" {
+			return fmt.Errorf("document id is required")
+		}
+
+		pieces, err := ChunkText(doc.Text, p.chunkModel(), p.ChunkTokens, p.ChunkOverlap)
+
+		if err != nil {
+			return err
+		}
+
+		for _, c := range pieces {
+			c.Id = fmt.Sprintf("%s#%d", doc.Id, c.Index)
+			c.DocumentId = doc.Id
+			c.Metadata = doc.Metadata
+			chunks = append(chunks, c)
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(chunks))
+	texts := make([]string, len(chunks))
+	metadata := make([]map[string]string, len(chunks))
+
+	for i, c := range chunks {
+
+		ids[i] = c.Id
+		texts[i] = c.Text
+		metadata[i] = map[string]string{DOCUMENT_KEY: c.DocumentId}
+
+		for k, v := range c.Metadata {
+			if k != DOCUMENT_KEY {
+				metadata[i][k] = v
+			}
+		}
+	}
+
+	emb, err := p.Embedder.Embed(ctx, texts)
+
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, doc := range docs {
+		p.Index.Delete(p.documents[doc.Id]...)
+		for _, id := range p.documents[doc.Id] {
+			delete(p.chunks, id)
+		}
+		delete(p.documents, doc.Id)
+	}
+
+	if err := p.Index.AddEmbeddings(emb.Data, ids, metadata); err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		p.chunks[c.Id] = c
+		p.documents[c.DocumentId] = append(p.documents[c.DocumentId], c.Id)
+	}
+
+	return nil
+}
+
+// Retrieve returns the TopK chunks most similar to the question that pass filter, best first.
+func (p *Pipeline) Retrieve(ctx context.Context, question string, filter vectorstore.Filter) ([]Source, error) {
+
+	emb, err := p.Embedder.Embed(ctx, []string{question})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(emb.Data) != 1 {
+		return nil, fmt.Errorf("expected 1 embedding, got %d", len(emb.Data))
+	}
+
+	k := p.TopK
+
+	if k <= 0 {
+		k = DEFAULT_TOP_K
+	}
+
+	results, err := p.Index.Search(emb.Data[0].Embedding, k, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var sources []Source
+
+	for _, r := range results {
+		if c, ok := p.chunks[r.Id]; ok {
+			sources = append(sources, Source{Chunk: c, Score: r.Score})
+		}
+	}
+
+	return sources, nil
+}
+
+// Messages builds the prompt for a question from as many sources as fit in ContextTokens, and returns the sources used.
+func (p *Pipeline) Messages(question string, sources []Source) ([]gogpt.GoGPTMessage, []Source, error) {
+
+	limit := p.ContextTokens
+
+	if limit <= 0 {
+		limit = DEFAULT_CONTEXT_TOKENS
+	}
+
+	var b strings.Builder
+	var used []Source
+
+	size := 0
+
+	for _, s := range sources {
+
+		block := fmt.Sprintf("[%s]\n%s\n\n", s.Id, s.Text)
+
+		n, err := gogpt.CountTokens(block, p.Query.Model)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if size+n > limit {
+			continue
+		}
+
+		b.WriteString(block)
+		size += n
+		used = append(used, s)
+	}
+
+	prompt := p.Prompt
+
+	if prompt == "" {
+		prompt = RAG_PROMPT
+	}
+
+	msgs := []gogpt.GoGPTMessage{
+		{Role: gogpt.ROLE_SYSTEM, Content: prompt},
+		{Role: gogpt.ROLE_SYSTEM, Content: "Sources:\n\n" + strings.TrimSpace(b.String())},
+		{Role: gogpt.ROLE_USER, Content: question},
+	}
+
+	return msgs, used, nil
+}
+
+// Ask answers a question from the indexed documents.
+func (p *Pipeline) Ask(ctx context.Context, question string) (*Answer, error) {
+	return p.AskFiltered(ctx, question, nil)
+}
+
+// AskFiltered answers a question using only chunks that pass filter.
+func (p *Pipeline) AskFiltered(ctx context.Context, question string, filter vectorstore.Filter) (*Answer, error) {
+
+	sources, err := p.Retrieve(ctx, question, filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, used, err := p.Messages(question, sources)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q := *p.Query
+	q.Messages = msgs
+
+	var completer gogpt.Completer = &q
+
+	if p.Completer != nil {
+		completer = p.Completer
+	}
+
+	resp, err := completer.Complete(ctx, &q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("response has no choices")
+	}
+
+	answer := &Answer{
+		Text:     resp.Choices[0].Message.Content,
+		Sources:  used,
+		Response: resp,
+	}
+
+	cited := map[string]bool{}
+
+	// Accept [a] and [a, b].
+	for _, m := range citation.FindAllStringSubmatch(answer.Text, -1) {
+		for _, id := range strings.Split(m[1], ",") {
+			cited[strings.TrimSpace(id)] = true
+		}
+	}
+
+	for _, s := range used {
+		if cited[s.Id] {
+			answer.Citations = append(answer.Citations, s)
+		}
+	}
+
+	return answer, nil
+}
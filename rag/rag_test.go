@@ -0,0 +1,165 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dratner/gogpt"
+	"github.com/dratner/gogpt/gogpttest"
+	"github.com/dratner/gogpt/vectorstore"
+)
+
+func TestMain(m *testing.M) {
+
+	gogpttest.UseOfflineTokenizer()
+
+	os.Exit(m.Run())
+}
+
+func TestChunkText(t *testing.T) {
+
+	text := strings.Repeat("Pigs can't fly, but cochons can dream. ", 40) + "Fin: élevage."
+
+	chunks, err := ChunkText(text, gogpt.MODEL_EMBEDDING_3_SMALL, 100, 20)
+
+	if err != nil {
+		t.Fatalf("error chunking: %v", err)
+	}
+
+	if len(chunks) < 3 {
+		t.Fatalf("expected several chunks, got %d", len(chunks))
+	}
+
+	for i, c := range chunks {
+
+		if c.Tokens > 100 || text[c.Start:c.End] != c.Text || c.Index != i {
+			t.Errorf("bad chunk %d: %+v", i, c)
+		}
+
+		if i > 0 && c.Start >= chunks[i-1].End {
+			t.Errorf("chunk %d does not overlap the one before", i)
+		}
+	}
+
+	if last := chunks[len(chunks)-1]; last.End != len(text) {
+		t.Errorf("the last chunk ends at %d, expected %d", last.End, len(text))
+	}
+
+	if _, err := ChunkText(text, gogpt.MODEL_EMBEDDING_3_SMALL, 10, 10); err == nil {
+		t.Errorf("expected an error for an overlap as large as the chunk")
+	}
+}
+
+func TestAsk(t *testing.T) {
+
+	topics := []string{"pig", "vacation", "cow"}
+
+	var asked gogpt.GoGPTQuery
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if strings.HasSuffix(r.URL.Path, "/embeddings") {
+
+			var req struct {
+				Input []string `json:"input"`
+			}
+
+			json.NewDecoder(r.Body).Decode(&req)
+
+			resp := gogpt.GoGPTEmbeddings{Object: "list"}
+
+			for i, input := range req.Input {
+				v := []float64{0.01, 0.01, 0.01}
+				for j, topic := range topics {
+					if strings.Contains(strings.ToLower(input), topic) {
+						v[j] = 1
+					}
+				}
+				resp.Data = append(resp.Data, gogpt.EmbeddingData{Embedding: v, Index: i})
+			}
+
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&asked)
+
+		fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"You get 25 days [handbook#0, made-up#9]."},"finish_reason":"stop"}],"usage":{"prompt_tokens":50,"completion_tokens":8,"total_tokens":58}}`)
+	}))
+	defer server.Close()
+
+	client := gogpt.NewClient("test-key", gogpt.WithBaseURL(server.URL))
+
+	p := New(client.NewQuery(), client.NewEmbeddingsQuery())
+	p.TopK = 2
+
+	err := p.Add(context.Background(),
+		Document{Id: "handbook", Text: "Every employee gets 25 vacation days a year.", Metadata: map[string]string{"team": "hr"}},
+		Document{Id: "farm", Text: "The pig pen is cleaned on Mondays."},
+		Document{Id: "dairy", Text: "The cow is milked twice a day."},
+	)
+
+	if err != nil {
+		t.Fatalf("error adding documents: %v", err)
+	}
+
+	answer, err := p.Ask(context.Background(), "How many vacation days do I get?")
+
+	if err != nil {
+		t.Fatalf("error asking: %v", err)
+	}
+
+	if len(answer.Sources) != 2 || answer.Sources[0].Id != "handbook#0" {
+		t.Errorf("expected the handbook to be the best source, got %+v", answer.Sources)
+	}
+
+	if len(answer.Citations) != 1 || answer.Citations[0].DocumentId != "handbook" || answer.Citations[0].End != 44 {
+		t.Errorf("unexpected citations: %+v", answer.Citations)
+	}
+
+	if len(asked.Messages) != 3 || !strings.Contains(asked.Messages[1].Content, "[handbook#0]\nEvery employee") || asked.Messages[2].Content != "How many vacation days do I get?" {
+		t.Errorf("unexpected prompt: %+v", asked.Messages)
+	}
+
+	// Replacing a document drops its old chunks.
+	if err := p.Add(context.Background(), Document{Id: "handbook", Text: "Vacation policy: see the wiki."}); err != nil {
+		t.Fatalf("error replacing document: %v", err)
+	}
+
+	if p.Index.Len() != 3 {
+		t.Errorf("expected 3 chunks after replacing, got %d", p.Index.Len())
+	}
+
+	sources, err := p.Retrieve(context.Background(), "vacation", vectorstore.Match(map[string]string{DOCUMENT_KEY: "farm"}))
+
+	if err != nil || len(sources) != 1 || sources[0].DocumentId != "farm" {
+		t.Errorf("unexpected filtered sources: %+v %v", sources, err)
+	}
+}
+
+func TestMessagesBudget(t *testing.T) {
+
+	p := New(gogpt.NewGoGPTQuery("test-key"), gogpt.NewGoGPTEmbeddingsQuery("test-key"))
+	p.ContextTokens = 60
+
+	sources := []Source{
+		{Chunk: Chunk{Id: "a#0", Text: strings.Repeat("long ", 40)}},
+		{Chunk: Chunk{Id: "b#0", Text: "short"}},
+	}
+
+	msgs, used, err := p.Messages("Why?", sources)
+
+	if err != nil {
+		t.Fatalf("error building messages: %v", err)
+	}
+
+	if len(used) != 1 || used[0].Id != "b#0" || strings.Contains(msgs[1].Content, "long") {
+		t.Errorf("expected only the source that fits, got %+v", used)
+	}
+}
@@ -0,0 +1,72 @@
+package gogpt
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+	A ChatRecorder keeps a permanent record of a chat, including the messages a ContextStrategy later
+	drops or summarizes away. After each successful reply GoGPTChat hands its Recorder a GoGPTTurn with
+	every message added since the previous reply (queued messages, tool results and anything appended
+	to the query directly) followed by the reply itself, along with the usage for the request.
+*/
+
+type GoGPTTurn struct {
+	SessionId string
+	Model     string
+	Messages  []GoGPTMessage
+	Usage     GoGPTUsage
+	Time      time.Time
+}
+
+type ChatRecorder interface {
+	RecordTurn(ctx context.Context, turn GoGPTTurn) error
+}
+
+// SetRecorder records every turn of the chat under the given session ID.
+func (c *GoGPTChat) SetRecorder(id string, r ChatRecorder) *GoGPTChat {
+
+	c.SessionId = id
+	c.Recorder = r
+
+	return c
+}
+
+// unrecorded returns the messages added to the history since the last reply.
+func (c *GoGPTChat) unrecorded() []GoGPTMessage {
+
+	msgs := c.Query.Messages
+	start := 0
+
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == ROLE_ASSISTANT {
+			start = i + 1
+			break
+		}
+	}
+
+	return append([]GoGPTMessage{}, msgs[start:]...)
+}
+
+func (c *GoGPTChat) record(ctx context.Context, msgs []GoGPTMessage, resp *GoGPTResponse) error {
+
+	turn := GoGPTTurn{
+		SessionId: c.SessionId,
+		Model:     resp.Model,
+		Messages:  msgs,
+		Usage:     resp.Usage,
+		Time:      time.Now().UTC(),
+	}
+
+	if turn.Model == "" {
+		turn.Model = c.Query.Model
+	}
+
+	if err := c.Recorder.RecordTurn(ctx, turn); err != nil {
+		return fmt.Errorf("could not record turn: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package gogpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testRecorder struct {
+	turns []GoGPTTurn
+	err   error
+}
+
+func (r *testRecorder) RecordTurn(ctx context.Context, turn GoGPTTurn) error {
+
+	r.turns = append(r.turns, turn)
+
+	return r.err
+}
+
+func TestChatRecorder(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	recorder := &testRecorder{}
+
+	chat := NewGoGPTChat("test-key").SetRecorder("farmer-1", recorder)
+	chat.Query.Endpoint = server.URL
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a farmer.").AddMessage(ROLE_USER, "", "What's the weather in Paris?")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	// Tool results go straight into the history, so they are picked up with the next turn.
+	chat.Query.AddTool("get_weather", "Get the weather for a city", testWeather{})
+	chat.Query.Messages[2].ToolCalls = []GoGPTToolCall{{Id: "call_1", Type: TOOL_TYPE_FUNCTION, Function: GoGPTFunctionCall{Name: "get_weather"}}}
+	chat.Query.AddToolResult("call_1", "Sunny")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if len(recorder.turns) != 2 {
+		t.Fatalf("expected 2 turns, got %+v", recorder.turns)
+	}
+
+	first, second := recorder.turns[0], recorder.turns[1]
+
+	if first.SessionId != "farmer-1" || first.Model != "gpt-4o" || len(first.Messages) != 3 || first.Messages[2].Content != "No." || first.Usage.TotalTokens != 6 {
+		t.Errorf("unexpected first turn: %+v", first)
+	}
+
+	if len(second.Messages) != 2 || second.Messages[0].ToolCallId != "call_1" || second.Messages[1].Role != ROLE_ASSISTANT {
+		t.Errorf("unexpected second turn: %+v", second)
+	}
+
+	// A failing recorder still returns the reply and keeps it in the history.
+	recorder.err = errors.New("disk full")
+	chat.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	resp, err := chat.Generate()
+
+	if err == nil || resp == nil || chat.Query.Messages[len(chat.Query.Messages)-1].Content != "No." {
+		t.Errorf("expected the reply and a recording error, got %v %v", resp, err)
+	}
+}
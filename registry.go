@@ -0,0 +1,157 @@
+package gogpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/*
+	A ToolRegistry pairs each tool the model may call with the Go function that implements it.
+	Handlers are typed: the registry reflects the parameter schema from T and decodes the model's
+	arguments into a T before calling the handler.
+
+	reg := NewToolRegistry()
+	RegisterTool(reg, "get_weather", "Get the weather for a city", func(ctx context.Context, w Weather) (interface{}, error) {
+		return lookupWeather(w.City)
+	})
+
+	resp, err := chat.RunTools(ctx, reg, 5)
+*/
+
+var ErrToolIterations = errors.New("tool loop did not finish")
+
+type toolHandler func(ctx context.Context, arguments string) (interface{}, error)
+
+type ToolRegistry struct {
+	tools    []GoGPTTool
+	handlers map[string]toolHandler
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		handlers: map[string]toolHandler{},
+	}
+}
+
+// RegisterTool adds a tool whose arguments are decoded into a T and passed to handler.
+func RegisterTool[T any](r *ToolRegistry, name string, desc string, handler func(context.Context, T) (interface{}, error)) error {
+
+	if _, ok := r.handlers[name]; ok {
+		return fmt.Errorf("tool %s already registered", name)
+	}
+
+	var zero T
+
+	f, err := reflectFunction(name, desc, zero)
+
+	if err != nil {
+		return err
+	}
+
+	r.tools = append(r.tools, GoGPTTool{
+		Type:     TOOL_TYPE_FUNCTION,
+		Function: f,
+	})
+
+	r.handlers[name] = func(ctx context.Context, arguments string) (interface{}, error) {
+
+		args := new(T)
+
+		if arguments != "" {
+			if err := json.Unmarshal([]byte(arguments), args); err != nil {
+				return nil, fmt.Errorf("could not decode arguments for %s: %v", name, err)
+			}
+		}
+
+		return handler(ctx, *args)
+	}
+
+	return nil
+}
+
+// Tools returns the tool definitions to send to the model.
+func (r *ToolRegistry) Tools() []GoGPTTool {
+	return r.tools
+}
+
+// Call runs the handler for a function call and returns its result as message content.
+func (r *ToolRegistry) Call(ctx context.Context, call GoGPTFunctionCall) (string, error) {
+
+	handler, ok := r.handlers[call.Name]
+
+	if !ok {
+		return "", fmt.Errorf("unknown tool %s", call.Name)
+	}
+
+	result, err := handler(ctx, call.Arguments)
+
+	if err != nil {
+		return "", err
+	}
+
+	if s, ok := result.(string); ok {
+		return s, nil
+	}
+
+	b, err := json.Marshal(result)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// dispatch runs a call and reports any failure to the model rather than aborting the loop.
+func (r *ToolRegistry) dispatch(ctx context.Context, call GoGPTFunctionCall) string {
+
+	content, err := r.Call(ctx, call)
+
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+
+	return content
+}
+
+/*
+	RunTools generates replies until the model stops calling tools, running every call through
+	the registry and feeding the results back. It gives up with ErrToolIterations after
+	maxIterations round trips, returning the last response it got.
+*/
+
+func (g *GoGPTChat) RunTools(ctx context.Context, r *ToolRegistry, maxIterations int) (*GoGPTResponse, error) {
+
+	g.Query.Tools = r.Tools()
+
+	var resp *GoGPTResponse
+	var err error
+
+	for i := 0; i < maxIterations; i++ {
+
+		resp, err = g.GenerateWithContext(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reply := resp.Choices[0].Message
+
+		if len(reply.ToolCalls) == 0 && reply.FunctionCall == nil {
+			return resp, nil
+		}
+
+		// Results go straight into the history so they stay next to the calls they answer.
+		for _, call := range reply.ToolCalls {
+			g.Query.AddToolResult(call.Id, r.dispatch(ctx, call.Function))
+		}
+
+		if reply.FunctionCall != nil {
+			g.Query.AddMessage(ROLE_FUNCTION, reply.FunctionCall.Name, r.dispatch(ctx, *reply.FunctionCall))
+		}
+	}
+
+	return resp, fmt.Errorf("%w after %d iterations", ErrToolIterations, maxIterations)
+}
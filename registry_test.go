@@ -0,0 +1,126 @@
+package gogpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testWeather struct {
+	City string `json:"city"`
+}
+
+func TestRunTools(t *testing.T) {
+
+	var requests []GoGPTQuery
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		q := GoGPTQuery{}
+		json.NewDecoder(r.Body).Decode(&q)
+		requests = append(requests, q)
+
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+				{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+				{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Oslo\"}"}}
+			]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"id":"chatcmpl-2","choices":[{"index":0,"message":{"role":"assistant","content":"Paris is sunny, Oslo I can't say."},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	reg := NewToolRegistry()
+
+	var cities []string
+
+	err := RegisterTool(reg, "get_weather", "Get the weather for a city", func(ctx context.Context, w testWeather) (interface{}, error) {
+		cities = append(cities, w.City)
+		if w.City == "Oslo" {
+			return nil, fmt.Errorf("no station in %s", w.City)
+		}
+		return map[string]string{"city": w.City, "forecast": "sunny"}, nil
+	})
+
+	if err != nil {
+		t.Fatalf("error registering tool: %v", err)
+	}
+
+	if err := RegisterTool(reg, "get_weather", "again", func(ctx context.Context, w testWeather) (interface{}, error) { return nil, nil }); err == nil {
+		t.Errorf("expected an error registering a duplicate tool")
+	}
+
+	chat := NewGoGPTChat("test-key")
+	chat.Query.Endpoint = server.URL
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a weather bot.").AddMessage(ROLE_USER, "", "Weather in Paris and Oslo?")
+
+	resp, err := chat.RunTools(context.Background(), reg, 5)
+
+	if err != nil {
+		t.Fatalf("error running tools: %v", err)
+	}
+
+	if resp.Choices[0].Message.Content != "Paris is sunny, Oslo I can't say." {
+		t.Errorf("unexpected final reply: %+v", resp.Choices[0].Message)
+	}
+
+	if len(cities) != 2 || cities[0] != "Paris" || cities[1] != "Oslo" {
+		t.Errorf("handler not called with decoded arguments: %v", cities)
+	}
+
+	if len(requests) != 2 || len(requests[0].Tools) != 1 {
+		t.Fatalf("unexpected requests: %+v", requests)
+	}
+
+	msgs := requests[1].Messages
+
+	if len(msgs) != 5 || len(msgs[2].ToolCalls) != 2 {
+		t.Fatalf("unexpected history: %+v", msgs)
+	}
+
+	if msgs[3].Role != ROLE_TOOL || msgs[3].ToolCallId != "call_1" || msgs[3].Content != `{"city":"Paris","forecast":"sunny"}` {
+		t.Errorf("unexpected first result: %+v", msgs[3])
+	}
+
+	if msgs[4].ToolCallId != "call_2" || msgs[4].Content != "error: no station in Oslo" {
+		t.Errorf("unexpected second result: %+v", msgs[4])
+	}
+}
+
+func TestRunToolsMaxIterations(t *testing.T) {
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `{"id":"chatcmpl-%d","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+			{"id":"call_%d","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}
+		]},"finish_reason":"tool_calls"}]}`, calls, calls)
+	}))
+	defer server.Close()
+
+	reg := NewToolRegistry()
+	RegisterTool(reg, "get_weather", "Get the weather for a city", func(ctx context.Context, w testWeather) (interface{}, error) {
+		return "sunny", nil
+	})
+
+	chat := NewGoGPTChat("test-key")
+	chat.Query.Endpoint = server.URL
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a weather bot.").AddMessage(ROLE_USER, "", "Weather in Paris?")
+
+	_, err := chat.RunTools(context.Background(), reg, 3)
+
+	if !errors.Is(err, ErrToolIterations) {
+		t.Errorf("expected ErrToolIterations, got %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
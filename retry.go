@@ -0,0 +1,183 @@
+package gogpt
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+/*
+	Rate limits and transient failures are described here: https://platform.openai.com/docs/guides/rate-limits
+
+	A RetryPolicy decides whether a failed request should be sent again and how long to wait first.
+	Server supplied hints win over our own schedule: Retry-After (or retry-after-ms) is honored first,
+	then the x-ratelimit-reset-* headers on a 429, and only then exponential backoff with jitter.
+*/
+
+type RetryPolicy struct {
+	MaxAttempts     int           // total attempts including the first, so 1 disables retries
+	BaseBackoff     time.Duration // delay before the first retry, doubled on each attempt
+	MaxBackoff      time.Duration // upper bound on any single delay, including server hints
+	Jitter          float64       // fraction of the delay to randomize, from 0 to 1
+	RetryStatuses   []int         // HTTP status codes worth retrying
+	RetryErrorTypes []string      // GoGPTError.ErrType values worth retrying
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: RETRIES,
+		BaseBackoff: 500 * time.Millisecond,
+		MaxBackoff:  30 * time.Second,
+		Jitter:      0.2,
+		RetryStatuses: []int{
+			http.StatusRequestTimeout,
+			http.StatusConflict,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		RetryErrorTypes: []string{"server_error", "requests", "tokens"},
+	}
+}
+
+// Retryable reports whether the outcome of a single attempt is worth trying again.
+func (p *RetryPolicy) Retryable(resp *resty.Response, err error) bool {
+
+	// Network errors are usually transient.
+	if err != nil || resp == nil {
+		return true
+	}
+
+	body := new(GoGPTResponse)
+	parsed := resp.StatusCode() >= 400 && json.Unmarshal(resp.Body(), body) == nil && body.Error != nil
+
+	// Running out of credit also comes back as a 429, but waiting won't fix billing.
+	if parsed && body.Error.Code == "insufficient_quota" {
+		return false
+	}
+
+	for _, s := range p.RetryStatuses {
+		if resp.StatusCode() == s {
+			return true
+		}
+	}
+
+	if !parsed {
+		return false
+	}
+
+	for _, t := range p.RetryErrorTypes {
+		if body.Error.ErrType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Backoff returns how long to wait before retry number attempt (starting at 0).
+func (p *RetryPolicy) Backoff(attempt int, resp *resty.Response) time.Duration {
+
+	if d, ok := retryHint(resp); ok {
+		return p.cap(d)
+	}
+
+	d := float64(p.BaseBackoff) * math.Pow(2, float64(attempt))
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return p.cap(time.Duration(d))
+}
+
+func (p *RetryPolicy) cap(d time.Duration) time.Duration {
+
+	if d < 0 {
+		return 0
+	}
+
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return d
+}
+
+// retryHint reads how long the server asked us to wait, if it said.
+func retryHint(resp *resty.Response) (time.Duration, bool) {
+
+	if resp == nil {
+		return 0, false
+	}
+
+	h := resp.Header()
+
+	if ms := h.Get("retry-after-ms"); ms != "" {
+		if n, err := strconv.ParseFloat(ms, 64); err == nil {
+			return time.Duration(n * float64(time.Millisecond)), true
+		}
+	}
+
+	if ra := h.Get("Retry-After"); ra != "" {
+		if n, err := strconv.Atoi(ra); err == nil {
+			return time.Duration(n) * time.Second, true
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return time.Until(t), true
+		}
+	}
+
+	if resp.StatusCode() != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	// Wait for whichever limit resets last.
+	rl := parseRateLimit(h)
+
+	if rl.ResetRequests > rl.ResetTokens {
+		return rl.ResetRequests, true
+	}
+
+	return rl.ResetTokens, rl.ResetTokens > 0
+}
+
+// Do calls send until it succeeds, the policy gives up, or ctx is done. Backends outside this package send through it too.
+func (p *RetryPolicy) Do(ctx context.Context, send func() (*resty.Response, error)) (*resty.Response, error) {
+
+	attempts := p.MaxAttempts
+
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		resp, err := send()
+
+		if attempt+1 >= attempts || ctx.Err() != nil || !p.Retryable(resp, err) {
+			return resp, err
+		}
+
+		timer := time.NewTimer(p.Backoff(attempt, resp))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package gogpt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testReply = `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"message":{"role":"assistant","content":"No."},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}`
+
+func TestRetryOnRateLimit(t *testing.T) {
+
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`)
+			return
+		}
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	resp, err := gpt.Generate()
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+
+	if resp.Choices[0].Message.Content != "No." {
+		t.Errorf("unexpected reply: %+v", resp)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "upstream unavailable")
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.Retry = DefaultRetryPolicy()
+	gpt.Retry.MaxAttempts = 4
+	gpt.Retry.BaseBackoff = time.Millisecond
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if _, err := gpt.Generate(); err == nil {
+		t.Errorf("expected an error after exhausting retries")
+	}
+
+	if calls != 4 {
+		t.Errorf("expected 4 calls, got %d", calls)
+	}
+}
+
+func TestRetryNotOnClientError(t *testing.T) {
+
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"bad request","type":"invalid_request_error"}}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if _, err := gpt.Generate(); err == nil {
+		t.Errorf("expected an error for a bad request")
+	}
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestRetryNotOnInsufficientQuota(t *testing.T) {
+
+	var calls int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.Retry = DefaultRetryPolicy()
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if _, err := gpt.Generate(); !errors.Is(err, ErrInsufficientQuota) {
+		t.Errorf("expected an insufficient quota error, got %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	p := &RetryPolicy{
+		BaseBackoff: 100 * time.Millisecond,
+		MaxBackoff:  time.Second,
+	}
+
+	if d := p.Backoff(0, nil); d != 100*time.Millisecond {
+		t.Errorf("unexpected first backoff: %v", d)
+	}
+
+	if d := p.Backoff(2, nil); d != 400*time.Millisecond {
+		t.Errorf("unexpected third backoff: %v", d)
+	}
+
+	if d := p.Backoff(10, nil); d != time.Second {
+		t.Errorf("backoff not capped: %v", d)
+	}
+
+	p.Jitter = 0.5
+
+	for i := 0; i < 20; i++ {
+		if d := p.Backoff(0, nil); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Errorf("jittered backoff out of range: %v", d)
+		}
+	}
+}
+
+func TestRetryRateLimitReset(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-reset-requests", "250ms")
+		w.Header().Set("x-ratelimit-reset-tokens", "1.5s")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.Retry = &RetryPolicy{MaxAttempts: 1}
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	req, _ := gpt.request()
+	resp, err := req.Post(gpt.Endpoint)
+
+	if err != nil {
+		t.Fatalf("error posting: %v", err)
+	}
+
+	p := &RetryPolicy{BaseBackoff: time.Millisecond, MaxBackoff: time.Minute}
+
+	if d := p.Backoff(0, resp); d != 1500*time.Millisecond {
+		t.Errorf("expected the later reset to win, got %v", d)
+	}
+}
@@ -0,0 +1,429 @@
+/*
+Package sqlstore keeps chats in a SQLite database through database/sql. It saves chat state as a
+gogpt.ChatStore and, as a gogpt.ChatRecorder, keeps every message ever exchanged with the usage
+of each turn, so conversations can be audited or rebuilt with a different strategy later.
+
+Bring your own driver, for example:
+
+	import _ "modernc.org/sqlite"
+
+	db, err := sql.Open("sqlite", "chats.db")
+	store, err := sqlstore.New(ctx, db)
+
+	chat := client.NewChat().SetRecorder("session-42", store)
+	err = chat.Save(ctx, store, "session-42")
+
+Timestamps are stored as fixed width UTC text so they sort and compare correctly in SQL.
+*/
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dratner/gogpt"
+)
+
+const (
+	TIME_FORMAT = "2006-01-02T15:04:05.000000000Z"
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS gogpt_sessions (
+		id TEXT PRIMARY KEY,
+		state TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS gogpt_turns (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		model TEXT NOT NULL,
+		prompt_tokens INTEGER NOT NULL,
+		completion_tokens INTEGER NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS gogpt_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id TEXT NOT NULL,
+		turn_id INTEGER NOT NULL REFERENCES gogpt_turns(id),
+		role TEXT NOT NULL,
+		name TEXT NOT NULL,
+		content TEXT NOT NULL,
+		function_call TEXT,
+		tool_calls TEXT,
+		tool_call_id TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS gogpt_turns_session ON gogpt_turns (session_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS gogpt_messages_session ON gogpt_messages (session_id, created_at)`,
+	`CREATE INDEX IF NOT EXISTS gogpt_messages_role ON gogpt_messages (role, created_at)`,
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+// New creates the tables if needed and returns a store using db.
+func New(ctx context.Context, db *sql.DB) (*Store, error) {
+
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("could not create schema: %v", err)
+		}
+	}
+
+	return &Store{db: db}, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(TIME_FORMAT)
+}
+
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(TIME_FORMAT, s)
+}
+
+/*
+	Chat state
+*/
+
+func (s *Store) Save(ctx context.Context, id string, state *gogpt.GoGPTChatState) error {
+
+	if id == "" {
+		return fmt.Errorf("session id is required")
+	}
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO gogpt_sessions (id, state, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET state = excluded.state, updated_at = excluded.updated_at`,
+		id, string(data), formatTime(time.Now()))
+
+	return err
+}
+
+func (s *Store) Load(ctx context.Context, id string) (*gogpt.GoGPTChatState, error) {
+
+	var data string
+
+	err := s.db.QueryRowContext(ctx, `SELECT state FROM gogpt_sessions WHERE id = ?`, id).Scan(&data)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", gogpt.ErrChatNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	state := new(gogpt.GoGPTChatState)
+
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, err
+	}
+
+	if state.Version < 1 || state.Version > gogpt.CHAT_STATE_VERSION {
+		return nil, fmt.Errorf("unsupported chat state version %d", state.Version)
+	}
+
+	return state, nil
+}
+
+func (s *Store) List(ctx context.Context) ([]string, error) {
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM gogpt_sessions ORDER BY id`)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Delete removes the saved state for a session. Its recorded history is kept.
+func (s *Store) Delete(ctx context.Context, id string) error {
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM gogpt_sessions WHERE id = ?`, id)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s", gogpt.ErrChatNotFound, id)
+	}
+
+	return nil
+}
+
+/*
+	History
+*/
+
+type Turn struct {
+	Id        int64
+	SessionId string
+	Model     string
+	Usage     gogpt.GoGPTUsage
+	CreatedAt time.Time
+}
+
+type Message struct {
+	gogpt.GoGPTMessage
+	Id        int64
+	SessionId string
+	TurnId    int64
+	CreatedAt time.Time
+}
+
+// A Filter narrows history queries. Zero fields match everything.
+type Filter struct {
+	SessionId string
+	Role      string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// RecordTurn stores a turn and its messages in one transaction.
+func (s *Store) RecordTurn(ctx context.Context, turn gogpt.GoGPTTurn) error {
+
+	if turn.Time.IsZero() {
+		turn.Time = time.Now()
+	}
+
+	created := formatTime(turn.Time)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO gogpt_turns (session_id, model, prompt_tokens, completion_tokens, created_at) VALUES (?, ?, ?, ?, ?)`,
+		turn.SessionId, turn.Model, turn.Usage.PromptTokens, turn.Usage.CompletionTokens, created)
+
+	if err != nil {
+		return err
+	}
+
+	turnId, err := res.LastInsertId()
+
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range turn.Messages {
+
+		functionCall, err := encode(msg.FunctionCall, msg.FunctionCall == nil)
+
+		if err != nil {
+			return err
+		}
+
+		toolCalls, err := encode(msg.ToolCalls, len(msg.ToolCalls) == 0)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO gogpt_messages (session_id, turn_id, role, name, content, function_call, tool_calls, tool_call_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			turn.SessionId, turnId, msg.Role, msg.Name, msg.Content, functionCall, toolCalls, msg.ToolCallId, created)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// encode stores v as JSON, or NULL when empty.
+func encode(v interface{}, empty bool) (sql.NullString, error) {
+
+	if empty {
+		return sql.NullString{}, nil
+	}
+
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// where builds the conditions for a filter.
+func where(f Filter, role bool) (string, []interface{}) {
+
+	var conds []string
+	var args []interface{}
+
+	if f.SessionId != "" {
+		conds = append(conds, "session_id = ?")
+		args = append(args, f.SessionId)
+	}
+
+	if role && f.Role != "" {
+		conds = append(conds, "role = ?")
+		args = append(args, f.Role)
+	}
+
+	if !f.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, formatTime(f.Since))
+	}
+
+	if !f.Until.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, formatTime(f.Until))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func limit(f Filter) string {
+
+	if f.Limit <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" LIMIT %d", f.Limit)
+}
+
+// History returns recorded messages matching the filter, oldest first.
+func (s *Store) History(ctx context.Context, f Filter) ([]Message, error) {
+
+	conds, args := where(f, true)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, session_id, turn_id, role, name, content, function_call, tool_calls, tool_call_id, created_at
+		FROM gogpt_messages`+conds+` ORDER BY id`+limit(f), args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []Message
+
+	for rows.Next() {
+
+		var m Message
+		var functionCall, toolCalls sql.NullString
+		var created string
+
+		err := rows.Scan(&m.Id, &m.SessionId, &m.TurnId, &m.Role, &m.Name, &m.Content, &functionCall, &toolCalls, &m.ToolCallId, &created)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if functionCall.Valid {
+			if err := json.Unmarshal([]byte(functionCall.String), &m.FunctionCall); err != nil {
+				return nil, err
+			}
+		}
+
+		if toolCalls.Valid {
+			if err := json.Unmarshal([]byte(toolCalls.String), &m.ToolCalls); err != nil {
+				return nil, err
+			}
+		}
+
+		if m.CreatedAt, err = parseTime(created); err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, rows.Err()
+}
+
+// Turns returns recorded turns and their usage matching the filter, oldest first. Filter.Role is ignored.
+func (s *Store) Turns(ctx context.Context, f Filter) ([]Turn, error) {
+
+	conds, args := where(f, false)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, session_id, model, prompt_tokens, completion_tokens, created_at
+		FROM gogpt_turns`+conds+` ORDER BY id`+limit(f), args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var turns []Turn
+
+	for rows.Next() {
+
+		var t Turn
+		var created string
+
+		if err := rows.Scan(&t.Id, &t.SessionId, &t.Model, &t.Usage.PromptTokens, &t.Usage.CompletionTokens, &created); err != nil {
+			return nil, err
+		}
+
+		t.Usage.TotalTokens = t.Usage.PromptTokens + t.Usage.CompletionTokens
+
+		var err error
+
+		if t.CreatedAt, err = parseTime(created); err != nil {
+			return nil, err
+		}
+
+		turns = append(turns, t)
+	}
+
+	return turns, rows.Err()
+}
+
+// Conversation returns every message ever exchanged in a session, ready to replay into a new chat.
+func (s *Store) Conversation(ctx context.Context, sessionId string) ([]gogpt.GoGPTMessage, error) {
+
+	history, err := s.History(ctx, Filter{SessionId: sessionId})
+
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]gogpt.GoGPTMessage, len(history))
+
+	for i, m := range history {
+		msgs[i] = m.GoGPTMessage
+	}
+
+	return msgs, nil
+}
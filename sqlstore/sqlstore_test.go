@@ -0,0 +1,142 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dratner/gogpt"
+	_ "modernc.org/sqlite"
+)
+
+func openTestStore(t *testing.T) *Store {
+
+	db, err := sql.Open("sqlite", ":memory:")
+
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+
+	// Each connection to :memory: is a separate database.
+	db.SetMaxOpenConns(1)
+
+	t.Cleanup(func() { db.Close() })
+
+	store, err := New(context.Background(), db)
+
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	return store
+}
+
+func TestStoreState(t *testing.T) {
+
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	chat := gogpt.NewGoGPTChat("test-key")
+	chat.Query.AddMessage(gogpt.ROLE_SYSTEM, "", "You are a farmer.")
+	chat.Summary = "The user likes pigs."
+
+	if err := chat.Save(ctx, store, "farmer-1"); err != nil {
+		t.Fatalf("error saving: %v", err)
+	}
+
+	// Saving again replaces the state.
+	chat.Query.AddMessage(gogpt.ROLE_USER, "", "Can pigs fly?")
+
+	if err := chat.Save(ctx, store, "farmer-1"); err != nil {
+		t.Fatalf("error saving: %v", err)
+	}
+
+	resumed := gogpt.NewGoGPTChat("test-key")
+
+	if err := resumed.Load(ctx, store, "farmer-1"); err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	if len(resumed.Query.Messages) != 2 || resumed.Summary != "The user likes pigs." {
+		t.Errorf("unexpected state: %+v", resumed)
+	}
+
+	if ids, err := store.List(ctx); err != nil || len(ids) != 1 || ids[0] != "farmer-1" {
+		t.Errorf("unexpected sessions: %v %v", ids, err)
+	}
+
+	if err := store.Delete(ctx, "farmer-1"); err != nil {
+		t.Errorf("error deleting: %v", err)
+	}
+
+	if _, err := store.Load(ctx, "farmer-1"); !errors.Is(err, gogpt.ErrChatNotFound) {
+		t.Errorf("expected ErrChatNotFound, got %v", err)
+	}
+}
+
+func TestStoreHistory(t *testing.T) {
+
+	ctx := context.Background()
+	store := openTestStore(t)
+
+	start := time.Now()
+
+	turns := []gogpt.GoGPTTurn{
+		{Model: "gpt-4o", Usage: gogpt.GoGPTUsage{PromptTokens: 10, CompletionTokens: 2}, Messages: []gogpt.GoGPTMessage{
+			{Role: gogpt.ROLE_SYSTEM, Content: "You are a farmer."},
+			{Role: gogpt.ROLE_USER, Content: "What's the weather in Paris?"},
+			{Role: gogpt.ROLE_ASSISTANT, ToolCalls: []gogpt.GoGPTToolCall{{Id: "call_1", Type: gogpt.TOOL_TYPE_FUNCTION, Function: gogpt.GoGPTFunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}}}},
+		}},
+		{Model: "gpt-4o", Usage: gogpt.GoGPTUsage{PromptTokens: 20, CompletionTokens: 3}, Messages: []gogpt.GoGPTMessage{
+			{Role: gogpt.ROLE_TOOL, ToolCallId: "call_1", Content: "Sunny"},
+			{Role: gogpt.ROLE_ASSISTANT, Content: "It's sunny."},
+		}},
+		{Model: "gpt-4o-mini", Usage: gogpt.GoGPTUsage{PromptTokens: 5, CompletionTokens: 1}, Messages: []gogpt.GoGPTMessage{
+			{Role: gogpt.ROLE_USER, Content: "Can pigs fly?"},
+			{Role: gogpt.ROLE_ASSISTANT, Content: "No."},
+		}},
+	}
+
+	for _, turn := range turns {
+		turn.SessionId = "farmer-1"
+		if err := store.RecordTurn(ctx, turn); err != nil {
+			t.Fatalf("error recording: %v", err)
+		}
+	}
+
+	if err := store.RecordTurn(ctx, gogpt.GoGPTTurn{SessionId: "farmer-2", Model: "gpt-4o", Messages: []gogpt.GoGPTMessage{{Role: gogpt.ROLE_USER, Content: "Hi"}}}); err != nil {
+		t.Fatalf("error recording: %v", err)
+	}
+
+	msgs, err := store.Conversation(ctx, "farmer-1")
+
+	if err != nil {
+		t.Fatalf("error reading history: %v", err)
+	}
+
+	if len(msgs) != 7 || msgs[0].Content != "You are a farmer." || msgs[2].ToolCalls[0].Function.Name != "get_weather" || msgs[3].ToolCallId != "call_1" {
+		t.Errorf("unexpected history: %+v", msgs)
+	}
+
+	users, err := store.History(ctx, Filter{SessionId: "farmer-1", Role: gogpt.ROLE_USER, Since: start})
+
+	if err != nil || len(users) != 2 || users[1].Content != "Can pigs fly?" || users[0].CreatedAt.Before(start.Add(-time.Second)) {
+		t.Errorf("unexpected user messages: %+v %v", users, err)
+	}
+
+	if none, err := store.History(ctx, Filter{Until: start.Add(-time.Hour)}); err != nil || len(none) != 0 {
+		t.Errorf("expected no messages before the test started, got %+v %v", none, err)
+	}
+
+	recorded, err := store.Turns(ctx, Filter{SessionId: "farmer-1"})
+
+	if err != nil || len(recorded) != 3 || recorded[2].Model != "gpt-4o-mini" || recorded[1].Usage.TotalTokens != 23 {
+		t.Errorf("unexpected turns: %+v %v", recorded, err)
+	}
+
+	if all, err := store.History(ctx, Filter{Limit: 100}); err != nil || len(all) != 8 {
+		t.Errorf("expected messages from both sessions, got %d %v", len(all), err)
+	}
+}
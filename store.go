@@ -0,0 +1,380 @@
+package gogpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	A GoGPTChatState is everything needed to resume a GoGPTChat: the query with its model settings,
+	functions, tools and history, the summary, any queued messages and the system prompt. Credentials,
+	endpoints, timeouts, retry policies, accountants and the chat's Strategy are not saved; they come
+	from the chat the state is restored into, so a conversation can be resumed with a fresh client.
+
+	The format is versioned. States written by a newer version of this package are refused rather
+	than half understood.
+
+	A ChatStore saves states by session ID. MemoryChatStore keeps them in memory and FileChatStore
+	writes one JSON file per session.
+*/
+
+const (
+	CHAT_STATE_VERSION = 1
+)
+
+var ErrChatNotFound = errors.New("chat not found")
+
+var sessionId = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,128}$`)
+
+type GoGPTChatState struct {
+	Version        int            `json:"version"`
+	SavedAt        time.Time      `json:"saved_at"`
+	Query          GoGPTQuery     `json:"query"`
+	RepairAttempts int            `json:"repair_attempts,omitempty"`
+	Tags           []string       `json:"tags,omitempty"`
+	Summary        string         `json:"summary,omitempty"`
+	MessageQueue   []GoGPTMessage `json:"message_queue,omitempty"`
+	Prompt         *GoGPTMessage  `json:"prompt,omitempty"`
+}
+
+type ChatStore interface {
+	Save(ctx context.Context, id string, state *GoGPTChatState) error
+	Load(ctx context.Context, id string) (*GoGPTChatState, error)
+	List(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// State captures the chat so it can be saved and restored later.
+func (c *GoGPTChat) State() *GoGPTChatState {
+
+	state := &GoGPTChatState{
+		Version:        CHAT_STATE_VERSION,
+		SavedAt:        time.Now().UTC(),
+		Query:          *c.Query,
+		RepairAttempts: c.Query.RepairAttempts,
+		Tags:           append([]string{}, c.Query.Tags...),
+		Summary:        c.Summary,
+		MessageQueue:   append([]GoGPTMessage{}, c.MessageQueue...),
+		Prompt:         c.systemPrompt(),
+	}
+
+	state.Query.Messages = append([]GoGPTMessage{}, c.Query.Messages...)
+
+	return state
+}
+
+// Restore replaces the chat's conversation and settings with a saved state, keeping its credentials and client.
+func (c *GoGPTChat) Restore(state *GoGPTChatState) error {
+
+	if state.Version < 1 || state.Version > CHAT_STATE_VERSION {
+		return fmt.Errorf("unsupported chat state version %d", state.Version)
+	}
+
+	if c.Query == nil {
+		c.Query = NewGoGPTQuery("")
+	}
+
+	q := state.Query
+	q.Messages = append([]GoGPTMessage{}, state.Query.Messages...)
+	q.Key = c.Query.Key
+	q.OrgName = c.Query.OrgName
+	q.OrgId = c.Query.OrgId
+	q.ProjectId = c.Query.ProjectId
+	q.Endpoint = c.Query.Endpoint
+	q.Timeout = c.Query.Timeout
+	q.Retry = c.Query.Retry
+	q.Accountant = c.Query.Accountant
+	q.client = c.Query.client
+	q.RepairAttempts = state.RepairAttempts
+	q.Tags = append([]string{}, state.Tags...)
+
+	choice, err := decodeToolChoice(q.ToolChoice)
+
+	if err != nil {
+		return err
+	}
+
+	q.ToolChoice = choice
+
+	*c.Query = q
+	c.Summary = state.Summary
+	c.MessageQueue = append([]GoGPTMessage{}, state.MessageQueue...)
+	c.prompt = nil
+
+	if state.Prompt != nil {
+		prompt := *state.Prompt
+		c.prompt = &prompt
+	}
+
+	return nil
+}
+
+// decodeToolChoice turns a tool_choice decoded from JSON back into a string or a GoGPTToolChoice.
+func decodeToolChoice(choice interface{}) (interface{}, error) {
+
+	m, ok := choice.(map[string]interface{})
+
+	if !ok {
+		return choice, nil
+	}
+
+	data, err := json.Marshal(m)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tc GoGPTToolChoice
+
+	if err := json.Unmarshal(data, &tc); err != nil {
+		return nil, fmt.Errorf("could not restore tool choice: %v", err)
+	}
+
+	return tc, nil
+}
+
+// Save writes the chat's state to a store under the given session ID.
+func (c *GoGPTChat) Save(ctx context.Context, store ChatStore, id string) error {
+	return store.Save(ctx, id, c.State())
+}
+
+// Load restores the chat from the state saved under the given session ID.
+func (c *GoGPTChat) Load(ctx context.Context, store ChatStore, id string) error {
+
+	state, err := store.Load(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	return c.Restore(state)
+}
+
+func checkSessionId(id string) error {
+
+	if !sessionId.MatchString(id) || strings.Trim(id, ".") == "" {
+		return fmt.Errorf("invalid session id %q", id)
+	}
+
+	return nil
+}
+
+func decodeState(data []byte) (*GoGPTChatState, error) {
+
+	state := new(GoGPTChatState)
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	if state.Version < 1 || state.Version > CHAT_STATE_VERSION {
+		return nil, fmt.Errorf("unsupported chat state version %d", state.Version)
+	}
+
+	return state, nil
+}
+
+/*
+	MemoryChatStore keeps encoded states in memory, so saved states don't change when the chat does.
+*/
+
+type MemoryChatStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func NewMemoryChatStore() *MemoryChatStore {
+	return &MemoryChatStore{states: map[string][]byte{}}
+}
+
+func (m *MemoryChatStore) Save(ctx context.Context, id string, state *GoGPTChatState) error {
+
+	if err := checkSessionId(id); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(state)
+
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.states[id] = data
+
+	return nil
+}
+
+func (m *MemoryChatStore) Load(ctx context.Context, id string) (*GoGPTChatState, error) {
+
+	m.mu.Lock()
+	data, ok := m.states[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, id)
+	}
+
+	return decodeState(data)
+}
+
+func (m *MemoryChatStore) List(ctx context.Context) ([]string, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.states))
+
+	for id := range m.states {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (m *MemoryChatStore) Delete(ctx context.Context, id string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.states[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, id)
+	}
+
+	delete(m.states, id)
+
+	return nil
+}
+
+/*
+	FileChatStore writes each session to <Dir>/<id>.json. Files are replaced atomically so a crash
+	mid-save leaves the previous state intact.
+*/
+
+type FileChatStore struct {
+	Dir string
+}
+
+func NewFileChatStore(dir string) (*FileChatStore, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &FileChatStore{Dir: dir}, nil
+}
+
+func (f *FileChatStore) path(id string) (string, error) {
+
+	if err := checkSessionId(id); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(f.Dir, id+".json"), nil
+}
+
+func (f *FileChatStore) Save(ctx context.Context, id string, state *GoGPTChatState) error {
+
+	path, err := f.path(id)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.Dir, "."+id+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileChatStore) Load(ctx context.Context, id string) (*GoGPTChatState, error) {
+
+	path, err := f.path(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeState(data)
+}
+
+func (f *FileChatStore) List(ctx context.Context) ([]string, error) {
+
+	entries, err := os.ReadDir(f.Dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".json") {
+			ids = append(ids, strings.TrimSuffix(name, ".json"))
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (f *FileChatStore) Delete(ctx context.Context, id string) error {
+
+	path, err := f.path(id)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, id)
+	}
+
+	return err
+}
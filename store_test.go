@@ -0,0 +1,164 @@
+package gogpt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChatStores(t *testing.T) {
+
+	files, err := NewFileChatStore(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	for _, store := range []ChatStore{NewMemoryChatStore(), files} {
+		testChatStore(t, store)
+	}
+
+	// Credentials stay out of saved files.
+	if err := NewGoGPTChat("secret-key").AddMessage(ROLE_USER, "", "Hi").Save(context.Background(), files, "farmer-1"); err != nil {
+		t.Fatalf("error saving: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(files.Dir, "farmer-1.json"))
+
+	if err != nil || strings.Contains(string(data), "secret-key") || !strings.Contains(string(data), `"version": 1`) {
+		t.Errorf("unexpected file contents: %v %s", err, data)
+	}
+}
+
+func testChatStore(t *testing.T, store ChatStore) {
+
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	chat := NewGoGPTChat("secret-key")
+	chat.Query.Endpoint = server.URL
+	chat.Query.Model = MODEL_4o
+	chat.Query.Temperature = 0.2
+	chat.Query.Tags = []string{"farm"}
+	chat.Query.AddTool("get_weather", "Get the weather for a city", testWeather{})
+	chat.Summary = "The user likes pigs."
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a farmer.")
+
+	if _, err := chat.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	chat.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	if err := chat.Save(ctx, store, "farmer-1"); err != nil {
+		t.Fatalf("error saving: %v", err)
+	}
+
+	// Changes after saving don't leak into the store.
+	chat.Query.AddMessage(ROLE_USER, "", "Never mind.")
+
+	resumed := NewGoGPTChat("other-key")
+	resumed.Query.Endpoint = server.URL
+
+	if err := resumed.Load(ctx, store, "farmer-1"); err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	q := resumed.Query
+
+	if q.Key != "other-key" || q.Model != MODEL_4o || q.Temperature != 0.2 || len(q.Tools) != 1 || q.Tools[0].Function.Name != "get_weather" || q.Tags[0] != "farm" {
+		t.Errorf("settings were not restored: %+v", q)
+	}
+
+	if len(q.Messages) != 2 || resumed.Summary != "The user likes pigs." || len(resumed.MessageQueue) != 1 || resumed.prompt == nil || resumed.prompt.Content != "You are a farmer." {
+		t.Errorf("conversation was not restored: %+v %+v", q.Messages, resumed)
+	}
+
+	if _, err := resumed.Generate(); err != nil {
+		t.Errorf("error resuming: %v", err)
+	}
+
+	if ids, err := store.List(ctx); err != nil || len(ids) != 1 || ids[0] != "farmer-1" {
+		t.Errorf("unexpected sessions: %v %v", ids, err)
+	}
+
+	if err := store.Save(ctx, "../escape", chat.State()); err == nil {
+		t.Errorf("expected an error for an unsafe session id")
+	}
+
+	if err := store.Delete(ctx, "farmer-1"); err != nil {
+		t.Errorf("error deleting: %v", err)
+	}
+
+	if _, err := store.Load(ctx, "farmer-1"); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("expected ErrChatNotFound, got %v", err)
+	}
+
+	if err := store.Delete(ctx, "farmer-1"); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("expected ErrChatNotFound, got %v", err)
+	}
+}
+
+func TestChatStateVersion(t *testing.T) {
+
+	state := NewGoGPTChat("test-key").State()
+	state.Version = CHAT_STATE_VERSION + 1
+
+	if err := NewGoGPTChat("test-key").Restore(state); err == nil {
+		t.Errorf("expected an error for a newer state version")
+	}
+}
+
+func TestChatStateToolChoice(t *testing.T) {
+
+	files, err := NewFileChatStore(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	chat := NewGoGPTChat("test-key")
+	chat.Query.AddTool("get_weather", "Get the weather for a city", testWeather{})
+	chat.Query.RequireTool("get_weather")
+	chat.AddMessage(ROLE_USER, "", "Weather in Paris?")
+
+	if err := chat.Save(context.Background(), files, "weather"); err != nil {
+		t.Fatalf("error saving: %v", err)
+	}
+
+	resumed := NewGoGPTChat("test-key")
+
+	if err := resumed.Load(context.Background(), files, "weather"); err != nil {
+		t.Fatalf("error loading: %v", err)
+	}
+
+	choice, ok := resumed.Query.ToolChoice.(GoGPTToolChoice)
+
+	if !ok || choice.Type != TOOL_TYPE_FUNCTION || choice.Function.Name != "get_weather" {
+		t.Fatalf("expected a function choice, got %#v", resumed.Query.ToolChoice)
+	}
+
+	// The restored choice is still checked against the defined tools.
+	resumed.Query.Tools = nil
+
+	if err := resumed.Query.Validate(); err == nil {
+		t.Errorf("expected an error for a choice of an undefined function")
+	}
+
+	chat.Query.SetToolChoice(TOOL_CHOICE_REQUIRED)
+	chat.Save(context.Background(), files, "weather")
+
+	if err := resumed.Load(context.Background(), files, "weather"); err != nil || resumed.Query.ToolChoice != TOOL_CHOICE_REQUIRED {
+		t.Errorf("expected a string choice, got %#v %v", resumed.Query.ToolChoice, err)
+	}
+}
@@ -0,0 +1,494 @@
+package gogpt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+/*
+	A ContextStrategy decides what to do when a chat's history no longer fits in the model's context.
+	GoGPTChat calls Compact with the number of tokens its Query.Messages may use, as counted by
+	CountMessageTokens, once room has been left for the queued messages, function definitions and
+	the reply. Compact must shrink the history in place. If the history still doesn't fit
+	afterwards the chat returns an error instead of sending the request.
+
+	The built-in strategies are:
+
+	SlidingWindow drops the oldest messages, keeping the system prompt. It makes no API calls.
+	KeepFirstLast keeps the first and last few messages and drops the middle. It makes no API calls.
+	RollingSummary folds older messages into the chat's Summary, keeping the most recent verbatim.
+	Hierarchical summarizes the history in chunks, then summarizes the summaries.
+
+	A chat without a Strategy uses a RollingSummary that keeps nothing, so the history becomes the
+	prompt and a summary. Summarizing strategies take a Summarizer, which can use a cheaper model than
+	the chat itself. Tool calls and their results are never split by a cut, and summaries are built
+	from a plain text transcript so tool messages don't need their definitions.
+*/
+
+const (
+	SUMMARY_PROMPT            = "Summarize the following chat history. You must use less than %d words."
+	DEFAULT_SUMMARY_TOKENS    = 256
+	SUMMARY_TRANSCRIPT_PREFIX = "Summary of the earlier conversation: "
+)
+
+type ContextStrategy interface {
+	Compact(ctx context.Context, chat *GoGPTChat, budget int) error
+}
+
+// SetStrategy chooses how the chat shrinks its history when it outgrows the context window.
+func (c *GoGPTChat) SetStrategy(s ContextStrategy) *GoGPTChat {
+
+	c.Strategy = s
+
+	return c
+}
+
+func (c *GoGPTChat) strategy() ContextStrategy {
+
+	if c.Strategy == nil {
+		return &RollingSummary{}
+	}
+
+	return c.Strategy
+}
+
+// systemPrompt finds the chat's first system message and remembers it.
+func (c *GoGPTChat) systemPrompt() *GoGPTMessage {
+
+	if c.prompt == nil {
+		for _, msg := range c.Query.Messages {
+			if msg.Role == ROLE_SYSTEM {
+				msg := msg
+				c.prompt = &msg
+				break
+			}
+		}
+	}
+
+	return c.prompt
+}
+
+// history returns the chat's messages without the system prompt and the current summary.
+func (c *GoGPTChat) history() []GoGPTMessage {
+
+	prompt := c.systemPrompt()
+	seenPrompt := false
+
+	var msgs []GoGPTMessage
+
+	for _, msg := range c.Query.Messages {
+
+		if msg.Role == ROLE_SYSTEM {
+			if prompt != nil && !seenPrompt && msg.Content == prompt.Content {
+				seenPrompt = true
+				continue
+			}
+			if c.Summary != "" && msg.Content == c.Summary {
+				continue
+			}
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+// rebuild replaces the chat's messages with the prompt, the summary and the given history.
+func (c *GoGPTChat) rebuild(history []GoGPTMessage) {
+
+	msgs := []GoGPTMessage{}
+
+	if prompt := c.systemPrompt(); prompt != nil {
+		msgs = append(msgs, *prompt)
+	}
+
+	if c.Summary != "" {
+		msgs = append(msgs, GoGPTMessage{Role: ROLE_SYSTEM, Content: c.Summary})
+	}
+
+	c.Query.Messages = append(msgs, history...)
+}
+
+// isResult reports whether a message answers a tool or function call and so can't start a window.
+func isResult(msg GoGPTMessage) bool {
+	return msg.Role == ROLE_TOOL || msg.Role == ROLE_FUNCTION
+}
+
+// nextTurn moves i forward past any tool results so a cut at i doesn't orphan them.
+func nextTurn(msgs []GoGPTMessage, i int) int {
+
+	for i < len(msgs) && isResult(msgs[i]) {
+		i++
+	}
+
+	return i
+}
+
+// Transcript renders messages as plain text for a summarizer, including tool calls and results.
+func Transcript(msgs []GoGPTMessage) string {
+
+	var b strings.Builder
+
+	for _, msg := range msgs {
+
+		role := msg.Role
+
+		if msg.Name != "" {
+			role += " (" + msg.Name + ")"
+		}
+
+		if msg.Content != "" {
+			fmt.Fprintf(&b, "%s: %s\n", role, msg.Content)
+		}
+
+		if msg.FunctionCall != nil {
+			fmt.Fprintf(&b, "%s called %s(%s)\n", role, msg.FunctionCall.Name, msg.FunctionCall.Arguments)
+		}
+
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&b, "%s called %s(%s)\n", role, call.Function.Name, call.Function.Arguments)
+		}
+	}
+
+	return b.String()
+}
+
+/*
+	A Summarizer condenses a transcript with a chat completion. Model defaults to the chat's model,
+	MaxTokens to the chat's MaxTokens or DEFAULT_SUMMARY_TOKENS, and Prompt to SUMMARY_PROMPT with
+	the word limit filled in. Requests inherit the chat's credentials, client and accountant, and
+	are tagged with SUMMARY_TAG. They are sent through Completer, or the chat's Completer if unset.
+*/
+
+type Summarizer struct {
+	Model     string
+	Prompt    string
+	MaxTokens int
+	Completer Completer
+}
+
+func (s *Summarizer) maxTokens(base *GoGPTQuery) int {
+
+	if s != nil && s.MaxTokens > 0 {
+		return s.MaxTokens
+	}
+
+	if base.MaxTokens > 0 {
+		return base.MaxTokens
+	}
+
+	return DEFAULT_SUMMARY_TOKENS
+}
+
+// Summarize asks the model for a summary of transcript, using base for credentials and accounting.
+func (s *Summarizer) Summarize(ctx context.Context, base *GoGPTQuery, transcript string) (string, error) {
+
+	if s == nil {
+		s = &Summarizer{}
+	}
+
+	q := base.derive()
+	q.Model = base.Model
+	q.Tags = append(q.Tags, SUMMARY_TAG)
+	q.MaxTokens = s.maxTokens(base)
+
+	if s.Model != "" {
+		q.Model = s.Model
+	}
+
+	prompt := s.Prompt
+
+	if prompt == "" {
+		prompt = fmt.Sprintf(SUMMARY_PROMPT, q.MaxTokens)
+	}
+
+	q.AddMessage(ROLE_SYSTEM, "", prompt)
+	q.AddMessage(ROLE_USER, "", transcript)
+
+	var completer Completer = base
+
+	if s.Completer != nil {
+		completer = s.Completer
+	}
+
+	resp, err := completer.Complete(ctx, q)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("summary has no choices")
+	}
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// summarizer returns s, sending through the chat's Completer unless s has its own.
+func (c *GoGPTChat) summarizer(s *Summarizer) *Summarizer {
+
+	if s == nil {
+		s = &Summarizer{}
+	}
+
+	if s.Completer != nil || c.Completer == nil {
+		return s
+	}
+
+	with := *s
+	with.Completer = c.Completer
+
+	return &with
+}
+
+// checkRoom makes sure the prompt and a summary will fit before paying for one.
+func checkRoom(c *GoGPTChat, s *Summarizer, budget int) error {
+
+	size := 0
+
+	if prompt := c.systemPrompt(); prompt != nil {
+		n, err := CountMessageTokens([]GoGPTMessage{*prompt}, c.Query.Model)
+		if err != nil {
+			return err
+		}
+		size = n
+	}
+
+	if size+s.maxTokens(c.Query) > budget {
+		return fmt.Errorf("not enough tokens to summarize")
+	}
+
+	return nil
+}
+
+// withSummary prefixes a transcript with the chat's current summary, if any.
+func withSummary(c *GoGPTChat, transcript string) string {
+
+	if c.Summary == "" {
+		return transcript
+	}
+
+	return SUMMARY_TRANSCRIPT_PREFIX + c.Summary + "\n\n" + transcript
+}
+
+/*
+	SlidingWindow keeps the system prompt and drops the oldest messages until the history fits.
+*/
+
+type SlidingWindow struct{}
+
+func (s *SlidingWindow) Compact(ctx context.Context, c *GoGPTChat, budget int) error {
+
+	history := c.history()
+
+	for start := 0; start <= len(history); start = nextTurn(history, start+1) {
+
+		c.rebuild(history[start:])
+
+		size, err := CountMessageTokens(c.Query.Messages, c.Query.Model)
+
+		if err != nil {
+			return err
+		}
+
+		if size <= budget {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+/*
+	KeepFirstLast keeps the first First and last Last messages of the chat and drops everything
+	in between. The boundaries move to keep tool calls with their results.
+*/
+
+type KeepFirstLast struct {
+	First int
+	Last  int
+}
+
+func (k *KeepFirstLast) Compact(ctx context.Context, c *GoGPTChat, budget int) error {
+
+	msgs := c.Query.Messages
+
+	first := k.First
+
+	if first > len(msgs) {
+		first = len(msgs)
+	}
+	first = nextTurn(msgs, first)
+
+	last := len(msgs) - k.Last
+
+	if last < first {
+		last = first
+	}
+	last = nextTurn(msgs, last)
+
+	kept := append([]GoGPTMessage{}, msgs[:first]...)
+	c.Query.Messages = append(kept, msgs[last:]...)
+
+	return nil
+}
+
+/*
+	RollingSummary folds everything but the last Keep messages into the chat's Summary, which is
+	updated rather than replaced each time, and keeps the summary as a system message after the prompt.
+*/
+
+type RollingSummary struct {
+	Summarizer *Summarizer
+	Keep       int
+}
+
+func (r *RollingSummary) Compact(ctx context.Context, c *GoGPTChat, budget int) error {
+
+	if c.systemPrompt() == nil {
+		return fmt.Errorf("no prompt found")
+	}
+
+	if err := checkRoom(c, r.Summarizer, budget); err != nil {
+		return err
+	}
+
+	history := c.history()
+	cut := len(history) - r.Keep
+
+	if cut < 0 {
+		cut = 0
+	}
+	cut = nextTurn(history, cut)
+
+	if cut == 0 {
+		return nil
+	}
+
+	summary, err := c.summarizer(r.Summarizer).Summarize(ctx, c.Query, withSummary(c, Transcript(history[:cut])))
+
+	if err != nil {
+		return err
+	}
+
+	c.Summary = summary
+	c.rebuild(history[cut:])
+
+	return nil
+}
+
+/*
+	Hierarchical summarizes the history ChunkTokens at a time, then summarizes those summaries
+	together until one remains, so histories larger than the summarizer's own context can be
+	condensed. ChunkTokens defaults to half the summarizer model's context window.
+*/
+
+type Hierarchical struct {
+	Summarizer  *Summarizer
+	ChunkTokens int
+}
+
+func (h *Hierarchical) Compact(ctx context.Context, c *GoGPTChat, budget int) error {
+
+	if c.systemPrompt() == nil {
+		return fmt.Errorf("no prompt found")
+	}
+
+	if err := checkRoom(c, h.Summarizer, budget); err != nil {
+		return err
+	}
+
+	model := c.Query.Model
+
+	if h.Summarizer != nil && h.Summarizer.Model != "" {
+		model = h.Summarizer.Model
+	}
+
+	size := h.ChunkTokens
+
+	if size <= 0 {
+		size = MaxQueryTokens(model) / 2
+	}
+
+	history := c.history()
+
+	if len(history) == 0 {
+		return nil
+	}
+
+	var parts []string
+
+	if c.Summary != "" {
+		parts = append(parts, SUMMARY_TRANSCRIPT_PREFIX+c.Summary+"\n")
+	}
+
+	for _, msg := range history {
+		parts = append(parts, Transcript([]GoGPTMessage{msg}))
+	}
+
+	for {
+
+		chunks, err := chunk(parts, size, model)
+
+		if err != nil {
+			return err
+		}
+
+		parts = []string{}
+
+		for _, text := range chunks {
+
+			summary, err := c.summarizer(h.Summarizer).Summarize(ctx, c.Query, text)
+
+			if err != nil {
+				return err
+			}
+
+			parts = append(parts, summary+"\n")
+		}
+
+		if len(parts) == 1 {
+			break
+		}
+	}
+
+	c.Summary = strings.TrimSpace(parts[0])
+	c.rebuild(nil)
+
+	return nil
+}
+
+// chunk joins parts into pieces of at most size tokens, putting at least two parts in each piece so repeated passes always shrink.
+func chunk(parts []string, size int, model string) ([]string, error) {
+
+	var chunks []string
+	var current strings.Builder
+	count, tokens := 0, 0
+
+	for _, part := range parts {
+
+		n, err := CountTokens(part, model)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if count >= 2 && tokens+n > size {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			count, tokens = 0, 0
+		}
+
+		current.WriteString(part)
+		count++
+		tokens += n
+	}
+
+	if count > 0 {
+		chunks = append(chunks, current.String())
+	}
+
+	return chunks, nil
+}
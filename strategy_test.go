@@ -0,0 +1,178 @@
+package gogpt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testStrategyChat builds a chat whose history holds a prompt, a tool call with its result, and a few long turns.
+func testStrategyChat(endpoint string) *GoGPTChat {
+
+	chat := NewGoGPTChat("test-key")
+	chat.Query.Endpoint = endpoint
+	chat.Query.Model = MODEL_4o
+	chat.Query.MaxTokens = 50
+
+	chat.Query.AddMessage(ROLE_SYSTEM, "", "You are a farmer.")
+	chat.Query.AddMessage(ROLE_USER, "", "What's the weather in Paris?")
+	chat.Query.Messages = append(chat.Query.Messages,
+		GoGPTMessage{Role: ROLE_ASSISTANT, ToolCalls: []GoGPTToolCall{{Id: "call_1", Type: TOOL_TYPE_FUNCTION, Function: GoGPTFunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}}}},
+		GoGPTMessage{Role: ROLE_TOOL, ToolCallId: "call_1", Content: "Sunny"},
+	)
+
+	for i := 0; i < 4; i++ {
+		chat.Query.AddMessage(ROLE_USER, "", strings.Repeat("Tell me about pigs. ", 20))
+		chat.Query.AddMessage(ROLE_ASSISTANT, "", strings.Repeat("Pigs are clever. ", 20))
+	}
+
+	return chat
+}
+
+// testSummaryServer replies to every request with a numbered summary and records the requests.
+func testSummaryServer(t *testing.T, requests *[]GoGPTQuery) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var q GoGPTQuery
+
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+
+		*requests = append(*requests, q)
+
+		fmt.Fprintf(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"summary %d"},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`, len(*requests))
+	}))
+}
+
+func TestSlidingWindow(t *testing.T) {
+
+	chat := testStrategyChat("http://127.0.0.1:0")
+
+	full, _ := CountMessageTokens(chat.Query.Messages, chat.Query.Model)
+
+	if err := (&SlidingWindow{}).Compact(context.Background(), chat, full/2); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	msgs := chat.Query.Messages
+	size, _ := CountMessageTokens(msgs, chat.Query.Model)
+
+	if size > full/2 || msgs[0].Content != "You are a farmer." || len(msgs) >= 11 {
+		t.Errorf("expected the prompt and a shorter history, got %d tokens in %+v", size, msgs)
+	}
+
+	if isResult(msgs[1]) {
+		t.Errorf("the window starts with an orphaned tool result")
+	}
+}
+
+func TestKeepFirstLast(t *testing.T) {
+
+	chat := testStrategyChat("http://127.0.0.1:0")
+
+	// A cut after the tool call must keep its result too.
+	if err := (&KeepFirstLast{First: 3, Last: 2}).Compact(context.Background(), chat, 0); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	msgs := chat.Query.Messages
+
+	if len(msgs) != 6 || msgs[3].Role != ROLE_TOOL || msgs[4].Role != ROLE_USER {
+		t.Errorf("unexpected history: %+v", msgs)
+	}
+}
+
+func TestRollingSummary(t *testing.T) {
+
+	var requests []GoGPTQuery
+
+	server := testSummaryServer(t, &requests)
+	defer server.Close()
+
+	chat := testStrategyChat(server.URL)
+	chat.Summary = "The user asked about the weather."
+	chat.Query.Messages = append(chat.Query.Messages[:1], append([]GoGPTMessage{{Role: ROLE_SYSTEM, Content: chat.Summary}}, chat.Query.Messages[1:]...)...)
+
+	strategy := &RollingSummary{Summarizer: &Summarizer{Model: MODEL_4o_MINI, Prompt: "Summarize briefly."}, Keep: 2}
+
+	if err := strategy.Compact(context.Background(), chat, 10000); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	if len(requests) != 1 || requests[0].Model != MODEL_4o_MINI || requests[0].Messages[0].Content != "Summarize briefly." {
+		t.Fatalf("unexpected summarization request: %+v", requests)
+	}
+
+	transcript := requests[0].Messages[1].Content
+
+	if !strings.Contains(transcript, "The user asked about the weather.") || !strings.Contains(transcript, "get_weather") || !strings.Contains(transcript, "tool: Sunny") {
+		t.Errorf("transcript is missing the old summary or the tool call: %s", transcript)
+	}
+
+	msgs := chat.Query.Messages
+
+	if chat.Summary != "summary 1" || len(msgs) != 4 || msgs[1].Content != "summary 1" || msgs[2].Role != ROLE_USER {
+		t.Errorf("unexpected history: %+v", msgs)
+	}
+}
+
+func TestHierarchical(t *testing.T) {
+
+	var requests []GoGPTQuery
+
+	server := testSummaryServer(t, &requests)
+	defer server.Close()
+
+	chat := testStrategyChat(server.URL)
+
+	if err := (&Hierarchical{ChunkTokens: 100}).Compact(context.Background(), chat, 10000); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	if len(requests) < 3 {
+		t.Errorf("expected chunks and a summary of summaries, got %d requests", len(requests))
+	}
+
+	last := fmt.Sprintf("summary %d", len(requests))
+
+	if chat.Summary != last || len(chat.Query.Messages) != 2 || chat.Query.Messages[1].Content != last {
+		t.Errorf("unexpected history: %+v", chat.Query.Messages)
+	}
+}
+
+func TestChatStrategy(t *testing.T) {
+
+	var requests []GoGPTQuery
+
+	server := testSummaryServer(t, &requests)
+	defer server.Close()
+
+	RegisterModel(ModelInfo{Name: "tiny-test-model", ContextWindow: 2000, MaxOutputTokens: 100, PromptPrice: 1, CompletionPrice: 1})
+
+	chat := NewGoGPTChat("test-key").SetStrategy(&SlidingWindow{})
+	chat.Query.Endpoint = server.URL
+	chat.Query.Model = "tiny-test-model"
+	chat.Query.MaxTokens = 50
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a farmer.")
+
+	for i := 0; i < 8; i++ {
+		chat.AddMessage(ROLE_USER, "", strings.Repeat("Tell me about pigs. ", 75))
+		if _, err := chat.Generate(); err != nil {
+			t.Fatalf("error generating: %v", err)
+		}
+	}
+
+	if len(requests) != 8 {
+		t.Errorf("a sliding window should not make extra requests, got %d", len(requests))
+	}
+
+	if chat.Query.Messages[0].Content != "You are a farmer." {
+		t.Errorf("the prompt was dropped: %+v", chat.Query.Messages[0])
+	}
+}
@@ -0,0 +1,340 @@
+package gogpt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Streaming follows the Server-Sent Events format described here: https://platform.openai.com/docs/api-reference/chat/streaming
+
+	Each event is a line of the form "data: {chunk}" and the stream ends with "data: [DONE]".
+	Every chunk carries a delta for one or more choices which we stitch back together into a
+	normal GoGPTResponse as the stream is read.
+
+	A long reply can stream for minutes, so the query's Timeout doesn't bound the whole stream.
+	It bounds the wait for the response headers and then each wait for the next line, and the
+	stream fails with context.DeadlineExceeded when the server goes quiet for that long.
+*/
+
+const (
+	STREAM_DATA_PREFIX = "data:"
+	STREAM_DONE        = "[DONE]"
+)
+
+type GoGPTStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// GoGPTToolCallDelta is a fragment of the tool call at position Index in the reply.
+type GoGPTToolCallDelta struct {
+	Index    int               `json:"index"`
+	Id       string            `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Function GoGPTFunctionCall `json:"function"`
+}
+
+type GoGPTDelta struct {
+	Role         string               `json:"role,omitempty"`
+	Content      string               `json:"content,omitempty"`
+	FunctionCall *GoGPTFunctionCall   `json:"function_call,omitempty"`
+	ToolCalls    []GoGPTToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+type GoGPTStreamChoice struct {
+	Index        int        `json:"index"`
+	Delta        GoGPTDelta `json:"delta"`
+	FinishReason string     `json:"finish_reason"`
+}
+
+type GoGPTStreamChunk struct {
+	Error   *GoGPTError         `json:"error,omitempty"`
+	Id      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int32               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []GoGPTStreamChoice `json:"choices"`
+	Usage   *GoGPTUsage         `json:"usage,omitempty"`
+}
+
+/*
+	GoGPTStream reads chunks from an open streaming response. Call Recv until it returns io.EOF,
+	then Response returns the assembled reply. Always Close the stream when done with it.
+*/
+
+type GoGPTStream struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	idle    *idleTimer
+	reader  *bufio.Reader
+	resp    *GoGPTResponse
+	choices map[int]*GoGPTChoice
+	done    bool
+	query   *GoGPTQuery
+}
+
+func newGoGPTStream(query *GoGPTQuery, body io.ReadCloser, ctx context.Context, cancel context.CancelCauseFunc, idle *idleTimer) *GoGPTStream {
+	return &GoGPTStream{
+		query:   query,
+		body:    body,
+		ctx:     ctx,
+		cancel:  cancel,
+		idle:    idle,
+		reader:  bufio.NewReader(body),
+		resp:    new(GoGPTResponse),
+		choices: map[int]*GoGPTChoice{},
+	}
+}
+
+// Recv returns the next chunk from the stream, or io.EOF once the stream is finished.
+func (s *GoGPTStream) Recv() (*GoGPTStreamChunk, error) {
+
+	if s.done {
+		return nil, io.EOF
+	}
+
+	for {
+		s.idle.start()
+		line, err := s.reader.ReadBytes('\n')
+		s.idle.stop()
+
+		if err != nil && !(err == io.EOF && len(line) > 0) {
+			if err == io.EOF {
+				s.done = true
+			}
+			if s.ctx.Err() != nil {
+				return nil, context.Cause(s.ctx)
+			}
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+
+		// Blank lines separate events and lines starting with a colon are comments.
+		if !bytes.HasPrefix(line, []byte(STREAM_DATA_PREFIX)) {
+			if err == io.EOF {
+				s.done = true
+				return nil, io.EOF
+			}
+			continue
+		}
+
+		data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte(STREAM_DATA_PREFIX)))
+
+		if string(data) == STREAM_DONE {
+			s.done = true
+			s.query.record(s.resp)
+			return nil, io.EOF
+		}
+
+		chunk := new(GoGPTStreamChunk)
+
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return nil, err
+		}
+
+		if chunk.Error != nil {
+			return nil, newAPIError(nil, chunk.Error)
+		}
+
+		s.accumulate(chunk)
+
+		return chunk, nil
+	}
+}
+
+func (s *GoGPTStream) accumulate(chunk *GoGPTStreamChunk) {
+
+	if s.resp.Id == "" {
+		s.resp.Id = chunk.Id
+		s.resp.Created = chunk.Created
+		s.resp.Model = chunk.Model
+		s.resp.Object = "chat.completion"
+	}
+
+	if chunk.Usage != nil {
+		s.resp.Usage = *chunk.Usage
+	}
+
+	for _, c := range chunk.Choices {
+
+		choice, ok := s.choices[c.Index]
+
+		if !ok {
+			choice = &GoGPTChoice{Index: c.Index}
+			s.choices[c.Index] = choice
+		}
+
+		if c.Delta.Role != "" {
+			choice.Message.Role = c.Delta.Role
+		}
+
+		choice.Message.Content += c.Delta.Content
+
+		if c.Delta.FunctionCall != nil {
+			if choice.Message.FunctionCall == nil {
+				choice.Message.FunctionCall = new(GoGPTFunctionCall)
+			}
+			choice.Message.FunctionCall.Name += c.Delta.FunctionCall.Name
+			choice.Message.FunctionCall.Arguments += c.Delta.FunctionCall.Arguments
+		}
+
+		for _, tc := range c.Delta.ToolCalls {
+
+			for len(choice.Message.ToolCalls) <= tc.Index {
+				choice.Message.ToolCalls = append(choice.Message.ToolCalls, GoGPTToolCall{})
+			}
+
+			call := &choice.Message.ToolCalls[tc.Index]
+
+			if tc.Id != "" {
+				call.Id = tc.Id
+			}
+
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+
+		if c.FinishReason != "" {
+			choice.FinishReason = c.FinishReason
+		}
+	}
+}
+
+// Response returns the reply assembled from every chunk received so far.
+func (s *GoGPTStream) Response() *GoGPTResponse {
+
+	indexes := make([]int, 0, len(s.choices))
+	for i := range s.choices {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	s.resp.Choices = make([]GoGPTChoice, 0, len(indexes))
+	for _, i := range indexes {
+		s.resp.Choices = append(s.resp.Choices, *s.choices[i])
+	}
+
+	return s.resp
+}
+
+func (s *GoGPTStream) Close() error {
+	s.idle.stop()
+	defer s.cancel(nil)
+	return s.body.Close()
+}
+
+// idleTimer cancels a stream's context with a deadline error when a wait runs longer than timeout.
+type idleTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newIdleTimer(timeout time.Duration, cancel context.CancelCauseFunc) *idleTimer {
+
+	if timeout <= 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("stream received nothing for %v: %w", timeout, context.DeadlineExceeded)
+
+	t := &idleTimer{timeout: timeout, timer: time.AfterFunc(timeout, func() { cancel(err) })}
+	t.timer.Stop()
+
+	return t
+}
+
+func (t *idleTimer) start() {
+	if t != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimer) stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
+
+// GenerateStream sends the query with streaming enabled and returns a stream of incremental deltas.
+func (g *GoGPTQuery) GenerateStream(ctx context.Context) (*GoGPTStream, error) {
+
+	req, err := g.request()
+
+	if err != nil {
+		return nil, err
+	}
+
+	g.Stream = true
+	g.StreamOptions = &GoGPTStreamOptions{IncludeUsage: true}
+
+	defer func() {
+		g.Stream = false
+		g.StreamOptions = nil
+	}()
+
+	// The context lives as long as the stream, and the timeout only bounds each wait on the server.
+	ctx, cancel := context.WithCancelCause(ctx)
+	idle := newIdleTimer(g.Timeout, cancel)
+
+	idle.start()
+
+	resp, err := req.
+		SetContext(ctx).
+		SetHeader("Accept", "text/event-stream").
+		SetDoNotParseResponse(true).
+		Post(g.endpoint())
+
+	idle.stop()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+		cancel(nil)
+		return nil, err
+	}
+
+	body := resp.RawBody()
+
+	if resp.StatusCode() != http.StatusOK {
+
+		defer cancel(nil)
+		defer body.Close()
+
+		idle.start()
+		data, err := io.ReadAll(body)
+		idle.stop()
+
+		if err != nil {
+			return nil, err
+		}
+
+		gptResp := new(GoGPTResponse)
+		err = json.Unmarshal(data, gptResp)
+
+		apiErr := newAPIError(resp, gptResp.Error)
+
+		// A body that isn't a JSON error is reported as is.
+		if err != nil || gptResp.Error == nil {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+
+		return nil, apiErr
+	}
+
+	return newGoGPTStream(g, body, ctx, cancel, idle), nil
+}
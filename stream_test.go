@@ -0,0 +1,280 @@
+package gogpt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGenerateStream(t *testing.T) {
+
+	chunks := []string{
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Pigs "},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"can't fly."},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":4,"total_tokens":9}}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		if body["stream"] != true {
+			t.Errorf("stream flag not sent: %+v", body)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, ": keep-alive\n\ndata: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	stream, err := gpt.GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer stream.Close()
+
+	text := ""
+
+	for {
+		chunk, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("error reading stream: %v", err)
+		}
+
+		for _, c := range chunk.Choices {
+			text += c.Delta.Content
+		}
+	}
+
+	if text != "Pigs can't fly." {
+		t.Errorf("unexpected streamed text: %q", text)
+	}
+
+	resp := stream.Response()
+
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content != text {
+		t.Errorf("unexpected assembled response: %+v", resp)
+	}
+
+	if resp.Choices[0].Message.Role != ROLE_ASSISTANT || resp.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected assembled choice: %+v", resp.Choices[0])
+	}
+
+	if resp.Usage.TotalTokens != 9 {
+		t.Errorf("usage not captured: %+v", resp.Usage)
+	}
+
+	if gpt.Stream {
+		t.Errorf("stream flag left set on query")
+	}
+}
+
+func TestGenerateStreamFunctionCall(t *testing.T) {
+
+	chunks := []string{
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"role":"assistant","function_call":{"name":"walk","arguments":""}}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"function_call":{"arguments":"{\"distance\":"}}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{"function_call":{"arguments":"\"3\"}"}}}]}`,
+		`{"id":"chatcmpl-2","choices":[{"index":0,"delta":{},"finish_reason":"function_call"}]}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Walk three steps.")
+
+	stream, err := gpt.GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer stream.Close()
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("error reading stream: %v", err)
+			}
+			break
+		}
+	}
+
+	fc := stream.Response().Choices[0].Message.FunctionCall
+
+	if fc == nil || fc.Name != "walk" || fc.Arguments != `{"distance":"3"}` {
+		t.Errorf("unexpected function call: %+v", fc)
+	}
+}
+
+func TestGenerateStreamError(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"Incorrect API key provided","type":"invalid_request_error","code":"invalid_api_key"}}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("bad-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Hello")
+
+	if _, err := gpt.GenerateStream(context.Background()); err == nil {
+		t.Errorf("expected an error for a rejected stream")
+	}
+}
+
+func TestGenerateStreamToolCalls(t *testing.T) {
+
+	chunks := []string{
+		`{"id":"chatcmpl-3","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+		`{"id":"chatcmpl-3","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"Paris\"}"}}]}}]}`,
+		`{"id":"chatcmpl-3","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":"}}]}}]}`,
+		`{"id":"chatcmpl-3","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"\"Rome\"}"}}]}}]}`,
+		`{"id":"chatcmpl-3","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "Weather in Paris and Rome?")
+
+	stream, err := gpt.GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer stream.Close()
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("error reading stream: %v", err)
+			}
+			break
+		}
+	}
+
+	calls := stream.Response().Choices[0].Message.ToolCalls
+
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %+v", calls)
+	}
+
+	if calls[0].Id != "call_1" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected first call: %+v", calls[0])
+	}
+
+	if calls[1].Id != "call_2" || calls[1].Function.Arguments != `{"city":"Rome"}` {
+		t.Errorf("unexpected second call: %+v", calls[1])
+	}
+}
+
+func TestGenerateStreamTimeout(t *testing.T) {
+
+	stall := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		// Five chunks take longer than the timeout in total, but each arrives well within it.
+		for _, word := range []string{"Pigs ", "can't ", "fly, ", "sadly, ", "ever."} {
+			fmt.Fprintf(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", word)
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+
+		if r.URL.Path == "/stall" {
+			select {
+			case <-stall:
+			case <-r.Context().Done():
+			}
+		}
+
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	defer close(stall)
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.Timeout = 100 * time.Millisecond
+	gpt.AddMessage(ROLE_USER, "", "Can pigs fly?")
+
+	stream, err := gpt.GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer stream.Close()
+
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err != io.EOF {
+				t.Fatalf("expected the stream to outlive its timeout, got %v", err)
+			}
+			break
+		}
+	}
+
+	if stream.Response().Choices[0].Message.Content != "Pigs can't fly, sadly, ever." {
+		t.Errorf("unexpected response: %+v", stream.Response())
+	}
+
+	// A server that goes quiet for longer than the timeout fails the stream.
+	gpt.Endpoint = server.URL + "/stall"
+
+	stream, err = gpt.GenerateStream(context.Background())
+
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer stream.Close()
+
+	for {
+		if _, err = stream.Recv(); err != nil {
+			break
+		}
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+}
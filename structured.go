@@ -0,0 +1,195 @@
+package gogpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/invopop/jsonschema"
+)
+
+/*
+	Structured outputs are described here: https://platform.openai.com/docs/guides/structured-outputs
+
+	JSON mode only promises syntactically valid JSON. A json_schema response format goes further and
+	makes the model follow a schema, which we reflect from a Go type. In strict mode every property
+	is made required, so use pointers or zero values rather than omitempty for optional fields.
+	Maps are not supported in strict mode.
+*/
+
+const (
+	RESPONSE_FORMAT_TEXT        = "text"
+	RESPONSE_FORMAT_JSON_OBJECT = "json_object"
+	RESPONSE_FORMAT_JSON_SCHEMA = "json_schema"
+)
+
+type GoGPTJSONSchema struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Schema      *jsonschema.Schema `json:"schema"`
+	Strict      bool               `json:"strict,omitempty"`
+}
+
+type GoGPTResponseFormat struct {
+	Type       string           `json:"type"`
+	JSONSchema *GoGPTJSONSchema `json:"json_schema,omitempty"`
+}
+
+// SetJSONMode asks the model to reply with a JSON object. The prompt must still mention JSON.
+func (g *GoGPTQuery) SetJSONMode() *GoGPTQuery {
+
+	g.ResponseFormat = &GoGPTResponseFormat{Type: RESPONSE_FORMAT_JSON_OBJECT}
+
+	return g
+}
+
+// SetResponseSchema asks the model to reply with JSON matching the fields of obj.
+func (g *GoGPTQuery) SetResponseSchema(name string, obj interface{}, strict bool) (*GoGPTQuery, error) {
+
+	if reflect.TypeOf(obj).Name() == "" {
+		return nil, fmt.Errorf("could not determine type name")
+	}
+
+	schema := reflectSchema(obj, strict)
+
+	g.ResponseFormat = &GoGPTResponseFormat{
+		Type: RESPONSE_FORMAT_JSON_SCHEMA,
+		JSONSchema: &GoGPTJSONSchema{
+			Name:   name,
+			Schema: schema,
+			Strict: strict,
+		},
+	}
+
+	return g, nil
+}
+
+// reflectSchema builds the JSON schema for obj's type.
+func reflectSchema(obj interface{}, strict bool) *jsonschema.Schema {
+
+	r := &jsonschema.Reflector{DoNotReference: true, ExpandedStruct: true}
+	schema := r.Reflect(obj)
+	schema.Version = ""
+	schema.ID = ""
+
+	if strict {
+		requireAll(schema)
+	}
+
+	return schema
+}
+
+// requireAll marks every property of every object in the schema as required, as strict mode demands.
+func requireAll(s *jsonschema.Schema) {
+
+	if s == nil {
+		return
+	}
+
+	if s.Properties != nil {
+		s.Required = s.Properties.Keys()
+		for _, k := range s.Properties.Keys() {
+			if v, ok := s.Properties.Get(k); ok {
+				if p, ok := v.(*jsonschema.Schema); ok {
+					requireAll(p)
+				}
+			}
+		}
+	}
+
+	requireAll(s.Items)
+}
+
+func GenerateInto[T any](q *GoGPTQuery) (T, *GoGPTResponse, error) {
+	return GenerateIntoWithContext[T](context.Background(), q)
+}
+
+/*
+	GenerateIntoWithContext generates a reply and decodes it into a T. If the query has no response
+	format yet, a strict schema is reflected from T. Registered models without structured outputs
+	get the schema in a system message instead, with JSON mode if they support it. When the reply is not valid JSON for T, the
+	model is shown its mistake and asked again, up to q.RepairAttempts times. The repair turns are
+	removed from the query's history before returning.
+*/
+
+func GenerateIntoWithContext[T any](ctx context.Context, q *GoGPTQuery) (T, *GoGPTResponse, error) {
+	return CompleteInto[T](ctx, q, q)
+}
+
+// CompleteInto is like GenerateIntoWithContext but sends every attempt through c.
+func CompleteInto[T any](ctx context.Context, c Completer, q *GoGPTQuery) (T, *GoGPTResponse, error) {
+
+	var out T
+
+	history := len(q.Messages)
+
+	defer func() {
+		q.Messages = q.Messages[:history]
+	}()
+
+	if q.ResponseFormat == nil {
+
+		info, known := LookupModel(q.Model)
+
+		switch {
+		case !known || info.StructuredOutputs:
+			if _, err := q.SetResponseSchema(reflect.TypeOf(out).Name(), out, true); err != nil {
+				return out, nil, err
+			}
+		default:
+			if reflect.TypeOf(out).Name() == "" {
+				return out, nil, fmt.Errorf("could not determine type name")
+			}
+
+			schema, err := json.Marshal(reflectSchema(out, true))
+
+			if err != nil {
+				return out, nil, err
+			}
+
+			if info.JSONMode {
+				q.SetJSONMode()
+			}
+
+			q.AddMessage(ROLE_SYSTEM, "", fmt.Sprintf("Reply with only a JSON object matching this JSON schema:\n%s", schema))
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		resp, err := c.Complete(ctx, q)
+
+		if err != nil {
+			return out, nil, err
+		}
+
+		if len(resp.Choices) == 0 {
+			return out, resp, fmt.Errorf("response has no choices")
+		}
+
+		reply := resp.Choices[0].Message
+
+		if reply.Refusal != "" {
+			return out, resp, fmt.Errorf("model refused: %s", reply.Refusal)
+		}
+
+		out = *new(T)
+		dec := json.NewDecoder(bytes.NewReader([]byte(reply.Content)))
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(&out)
+
+		if err == nil {
+			return out, resp, nil
+		}
+
+		if attempt >= q.RepairAttempts {
+			return out, resp, fmt.Errorf("could not decode reply: %v", err)
+		}
+
+		q.AddMessage(ROLE_ASSISTANT, "", reply.Content)
+		q.AddMessage(ROLE_USER, "", fmt.Sprintf("That reply could not be parsed (%v). Reply again with only valid JSON that matches the schema.", err))
+	}
+}
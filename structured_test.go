@@ -0,0 +1,125 @@
+package gogpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testRecipe struct {
+	Title       string   `json:"title"`
+	Ingredients []string `json:"ingredients"`
+	Minutes     int      `json:"minutes,omitempty"`
+}
+
+func TestGenerateInto(t *testing.T) {
+
+	replies := []string{
+		`{\"title\": \"Pancakes\", \"ingredients\": [\"flour\"`,
+		`{\"title\": \"Pancakes\", \"ingredients\": [\"flour\", \"milk\"], \"minutes\": 20}`,
+	}
+
+	var requests []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		fmt.Fprintf(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"%s"},"finish_reason":"stop"}]}`, replies[len(requests)-1])
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.RepairAttempts = 1
+	gpt.AddMessage(ROLE_USER, "", "Give me a pancake recipe.")
+
+	recipe, resp, err := GenerateInto[testRecipe](gpt)
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	if resp == nil || recipe.Title != "Pancakes" || len(recipe.Ingredients) != 2 || recipe.Minutes != 20 {
+		t.Errorf("unexpected recipe: %+v", recipe)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected a repair request, got %d requests", len(requests))
+	}
+
+	// The default model has no structured outputs, so the schema goes in the prompt with JSON mode.
+	format := requests[0]["response_format"].(map[string]interface{})
+	messages := requests[0]["messages"].([]interface{})
+	instruction := messages[len(messages)-1].(map[string]interface{})
+
+	if format["type"] != RESPONSE_FORMAT_JSON_OBJECT || instruction["role"] != ROLE_SYSTEM || !strings.Contains(instruction["content"].(string), `"required":["title","ingredients","minutes"]`) {
+		t.Errorf("unexpected schema fallback: %+v %+v", format, instruction)
+	}
+
+	if len(requests[1]["messages"].([]interface{})) != 4 {
+		t.Errorf("repair request should include the bad reply and a correction")
+	}
+
+	if len(gpt.Messages) != 1 {
+		t.Errorf("repair turns left in history: %+v", gpt.Messages)
+	}
+}
+
+func TestGenerateIntoSchema(t *testing.T) {
+
+	var format map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		format, _ = body["response_format"].(map[string]interface{})
+		fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"{\"title\": \"Toast\", \"ingredients\": [\"bread\"], \"minutes\": 2}"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.Model = MODEL_4o_MINI
+	gpt.AddMessage(ROLE_USER, "", "Give me a toast recipe.")
+
+	if recipe, _, err := GenerateInto[testRecipe](gpt); err != nil || recipe.Title != "Toast" {
+		t.Fatalf("unexpected recipe: %+v %v", recipe, err)
+	}
+
+	schema, _ := format["json_schema"].(map[string]interface{})
+
+	if format["type"] != RESPONSE_FORMAT_JSON_SCHEMA || schema["name"] != "testRecipe" || schema["strict"] != true {
+		t.Fatalf("unexpected response format: %+v", format)
+	}
+
+	required := schema["schema"].(map[string]interface{})["required"].([]interface{})
+
+	if len(required) != 3 || len(gpt.Messages) != 1 {
+		t.Errorf("strict schema should require every field: %v", required)
+	}
+}
+
+func TestGenerateIntoInvalid(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"{\"title\": \"Pancakes\", \"chef\": \"me\"}"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.SetJSONMode()
+	gpt.AddMessage(ROLE_USER, "", "Give me a pancake recipe in JSON.")
+
+	if _, _, err := GenerateInto[testRecipe](gpt); err == nil {
+		t.Errorf("expected an error for a reply with unknown fields")
+	}
+
+	if gpt.ResponseFormat.Type != RESPONSE_FORMAT_JSON_OBJECT {
+		t.Errorf("existing response format was replaced: %+v", gpt.ResponseFormat)
+	}
+}
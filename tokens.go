@@ -0,0 +1,243 @@
+package gogpt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/invopop/jsonschema"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+/*
+	Token counting follows the rules in the OpenAI cookbook:
+	https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
+
+	Every message costs a few tokens of framing on top of its content, a name costs one more,
+	and every reply is primed with three tokens. Function and tool definitions are rendered into
+	the prompt in a compact form which we approximate the same way the cookbook does.
+*/
+
+const (
+	ENCODING_O200K  = tiktoken.MODEL_O200K_BASE
+	ENCODING_CL100K = tiktoken.MODEL_CL100K_BASE
+	REPLY_PRIMING   = 3
+)
+
+var (
+	encoderMu    sync.Mutex
+	encoderCache = map[string]*tiktoken.Tiktoken{}
+)
+
+// encodingName picks the tokenizer for a model, falling back by model family when tiktoken doesn't know it.
+func encodingName(model string) string {
+
+	if info, ok := LookupModel(model); ok && info.Encoding != "" {
+		return info.Encoding
+	}
+
+	if enc, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return enc
+	}
+
+	for prefix, enc := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return enc
+		}
+	}
+
+	for _, prefix := range []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"} {
+		if strings.HasPrefix(model, prefix) {
+			return ENCODING_O200K
+		}
+	}
+
+	return ENCODING_CL100K
+}
+
+// TokenEncoder returns the cached tiktoken encoder for a model.
+func TokenEncoder(model string) (*tiktoken.Tiktoken, error) {
+
+	name := encodingName(model)
+
+	encoderMu.Lock()
+	defer encoderMu.Unlock()
+
+	if tkm, ok := encoderCache[name]; ok {
+		return tkm, nil
+	}
+
+	tkm, err := tiktoken.GetEncoding(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s encoding for %s: %v", name, model, err)
+	}
+
+	encoderCache[name] = tkm
+
+	return tkm, nil
+}
+
+// CountTokens returns the number of tokens in text for the given model.
+func CountTokens(text string, model string) (int, error) {
+
+	tkm, err := TokenEncoder(model)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(tkm.Encode(text, nil, nil)), nil
+}
+
+// messageOverhead returns the framing cost of each message and of a name.
+func messageOverhead(model string) (int, int) {
+
+	if strings.HasPrefix(model, "gpt-3.5-turbo-0301") {
+		return 4, -1
+	}
+
+	return 3, 1
+}
+
+// CountMessageTokens counts the tokens a list of messages adds to a prompt, excluding reply priming.
+func CountMessageTokens(msgs []GoGPTMessage, model string) (int, error) {
+
+	tkm, err := TokenEncoder(model)
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := func(s string) int {
+		return len(tkm.Encode(s, nil, nil))
+	}
+
+	perMessage, perName := messageOverhead(model)
+
+	total := 0
+
+	for _, msg := range msgs {
+
+		total += perMessage + count(msg.Role) + count(msg.Content)
+
+		if msg.Name != "" {
+			total += count(msg.Name) + perName
+		}
+
+		if msg.FunctionCall != nil {
+			total += count(msg.FunctionCall.Name) + count(msg.FunctionCall.Arguments)
+		}
+
+		for _, call := range msg.ToolCalls {
+			total += count(call.Function.Name) + count(call.Function.Arguments)
+		}
+
+		if msg.ToolCallId != "" {
+			total += count(msg.ToolCallId)
+		}
+	}
+
+	return total, nil
+}
+
+// countFunctionTokens approximates how many tokens the function definitions add to the prompt.
+func countFunctionTokens(functions []GoGPTFunction, model string) (int, error) {
+
+	if len(functions) == 0 {
+		return 0, nil
+	}
+
+	tkm, err := TokenEncoder(model)
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := func(s string) int {
+		return len(tkm.Encode(s, nil, nil))
+	}
+
+	funcInit, propInit, propKey, enumInit, enumItem, funcEnd := 10, 3, 3, -3, 3, 12
+
+	if encodingName(model) == ENCODING_O200K {
+		funcInit = 7
+	}
+
+	total := funcEnd
+
+	for _, f := range functions {
+
+		total += funcInit + count(f.Name+":"+strings.TrimSuffix(f.Description, "."))
+
+		if f.Parameters == nil || f.Parameters.Properties == nil || len(f.Parameters.Properties.Keys()) == 0 {
+			continue
+		}
+
+		total += propInit
+
+		for _, key := range f.Parameters.Properties.Keys() {
+
+			total += propKey
+
+			v, _ := f.Parameters.Properties.Get(key)
+			p, ok := v.(*jsonschema.Schema)
+
+			if !ok || p == nil {
+				total += count(key)
+				continue
+			}
+
+			if len(p.Enum) > 0 {
+				total += enumInit
+				for _, item := range p.Enum {
+					total += enumItem + count(fmt.Sprint(item))
+				}
+			}
+
+			total += count(key + ":" + p.Type + ":" + strings.TrimSuffix(p.Description, "."))
+		}
+	}
+
+	return total, nil
+}
+
+/*
+	CountQueryTokens estimates the prompt tokens a query will be billed for: its messages, any
+	function or tool definitions, and the priming for the reply.
+*/
+
+func CountQueryTokens(q *GoGPTQuery) (int, error) {
+
+	total, err := CountMessageTokens(q.Messages, q.Model)
+
+	if err != nil {
+		return 0, err
+	}
+
+	overhead, err := queryOverhead(q)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return total + overhead, nil
+}
+
+// queryOverhead counts what a query adds to its messages: the function definitions and the reply priming.
+func queryOverhead(q *GoGPTQuery) (int, error) {
+
+	functions := append([]GoGPTFunction{}, q.Functions...)
+
+	for _, t := range q.Tools {
+		functions = append(functions, t.Function)
+	}
+
+	ft, err := countFunctionTokens(functions, q.Model)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return ft + REPLY_PRIMING, nil
+}
@@ -0,0 +1,80 @@
+package gogpt
+
+import (
+	"testing"
+)
+
+func TestEncodingName(t *testing.T) {
+
+	cases := map[string]string{
+		MODEL_4o:            ENCODING_O200K,
+		MODEL_4o_MINI:       ENCODING_O200K,
+		"gpt-4o-2024-08-06": ENCODING_O200K,
+		"o3-mini":           ENCODING_O200K,
+		MODEL_4:             ENCODING_CL100K,
+		MODEL_35_TURBO:      ENCODING_CL100K,
+		"my-fine-tune":      ENCODING_CL100K,
+	}
+
+	for model, want := range cases {
+		if got := encodingName(model); got != want {
+			t.Errorf("%s: expected %s, got %s", model, want, got)
+		}
+	}
+}
+
+func TestCountQueryTokens(t *testing.T) {
+
+	count := func(s string) int {
+		n, err := CountTokens(s, MODEL_4o)
+		if err != nil {
+			t.Fatalf("error counting tokens: %v", err)
+		}
+		return n
+	}
+
+	q := NewGoGPTQuery("test-key")
+	q.Model = MODEL_4o
+	q.AddMessage(ROLE_SYSTEM, "", "You are a detective.")
+	q.AddMessage(ROLE_USER, "paul", "Solve the Great Train Mystery.")
+
+	want := 3 + count(ROLE_SYSTEM) + count("You are a detective.") +
+		3 + count(ROLE_USER) + count("Solve the Great Train Mystery.") + count("paul") + 1 +
+		REPLY_PRIMING
+
+	got, err := CountQueryTokens(q)
+
+	if err != nil {
+		t.Fatalf("error counting query tokens: %v", err)
+	}
+
+	if got != want {
+		t.Errorf("expected %d tokens, got %d", want, got)
+	}
+
+	// Function definitions add to the prompt.
+	q.AddFunction("get_game_instruction", "Get game instruction from user input", testWeather{})
+
+	withFunctions, err := CountQueryTokens(q)
+
+	if err != nil {
+		t.Fatalf("error counting query tokens: %v", err)
+	}
+
+	if withFunctions <= got {
+		t.Errorf("functions were not counted: %d <= %d", withFunctions, got)
+	}
+
+	// So do the arguments of function calls in the history.
+	q.Messages = append(q.Messages, GoGPTMessage{Role: ROLE_ASSISTANT, FunctionCall: &GoGPTFunctionCall{Name: "get_game_instruction", Arguments: `{"city":"Paris"}`}})
+
+	withCall, err := CountQueryTokens(q)
+
+	if err != nil {
+		t.Fatalf("error counting query tokens: %v", err)
+	}
+
+	if withCall != withFunctions+3+count(ROLE_ASSISTANT)+count("get_game_instruction")+count(`{"city":"Paris"}`) {
+		t.Errorf("function call not counted: %d", withCall)
+	}
+}
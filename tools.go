@@ -0,0 +1,94 @@
+package gogpt
+
+/*
+	Tools replace the deprecated functions API: https://platform.openai.com/docs/api-reference/chat/create#chat-create-tools
+
+	A model may answer with several tool calls at once. Each call has an id, and the result of
+	running it is sent back as a ROLE_TOOL message carrying that id in ToolCallId.
+*/
+
+const (
+	TOOL_TYPE_FUNCTION   = "function"
+	TOOL_CHOICE_AUTO     = "auto"
+	TOOL_CHOICE_NONE     = "none"
+	TOOL_CHOICE_REQUIRED = "required"
+)
+
+type GoGPTTool struct {
+	Type     string        `json:"type"`
+	Function GoGPTFunction `json:"function"`
+}
+
+type GoGPTToolCall struct {
+	Id       string            `json:"id"`
+	Type     string            `json:"type"`
+	Function GoGPTFunctionCall `json:"function"`
+}
+
+type GoGPTToolChoiceFunction struct {
+	Name string `json:"name"`
+}
+
+// GoGPTToolChoice forces the model to call one specific function.
+type GoGPTToolChoice struct {
+	Type     string                  `json:"type"`
+	Function GoGPTToolChoiceFunction `json:"function"`
+}
+
+// AddTool registers a function tool whose parameters are the fields of obj, like AddFunction.
+func (g *GoGPTQuery) AddTool(name string, desc string, obj interface{}) (*GoGPTQuery, error) {
+
+	f, err := reflectFunction(name, desc, obj)
+
+	if err != nil {
+		return nil, err
+	}
+
+	g.Tools = append(g.Tools, GoGPTTool{
+		Type:     TOOL_TYPE_FUNCTION,
+		Function: f,
+	})
+
+	return g, nil
+}
+
+// SetToolChoice sets tool_choice to TOOL_CHOICE_AUTO, TOOL_CHOICE_NONE or TOOL_CHOICE_REQUIRED.
+func (g *GoGPTQuery) SetToolChoice(choice string) *GoGPTQuery {
+
+	g.ToolChoice = choice
+
+	return g
+}
+
+// RequireTool forces the model to call the named function.
+func (g *GoGPTQuery) RequireTool(name string) *GoGPTQuery {
+
+	g.ToolChoice = GoGPTToolChoice{
+		Type:     TOOL_TYPE_FUNCTION,
+		Function: GoGPTToolChoiceFunction{Name: name},
+	}
+
+	return g
+}
+
+// SetParallelToolCalls allows or forbids the model from making several tool calls in one turn.
+func (g *GoGPTQuery) SetParallelToolCalls(parallel bool) *GoGPTQuery {
+
+	g.ParallelToolCalls = &parallel
+
+	return g
+}
+
+// AddToolResult answers the tool call with the given id.
+func (g *GoGPTQuery) AddToolResult(id string, content string) *GoGPTQuery {
+
+	msg := GoGPTMessage{
+		Role:       ROLE_TOOL,
+		Content:    content,
+		ToolCallId: id,
+	}
+
+	g.Messages = append(g.Messages, msg)
+
+	return g
+}
@@ -0,0 +1,110 @@
+package gogpt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerateWithTools(t *testing.T) {
+
+	type Weather struct {
+		City string `json:"city"`
+	}
+
+	var requests []map[string]interface{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[
+				{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+				{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Rome\"}"}}
+			]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"id":"chatcmpl-2","choices":[{"index":0,"message":{"role":"assistant","content":"Sunny in both."},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	gpt := NewGoGPTQuery("test-key")
+	gpt.Endpoint = server.URL
+	gpt.AddMessage(ROLE_USER, "", "What's the weather in Paris and Rome?")
+
+	if _, err := gpt.AddTool("get_weather", "Get the weather for a city", Weather{}); err != nil {
+		t.Fatalf("error adding tool: %v", err)
+	}
+
+	gpt.SetToolChoice(TOOL_CHOICE_REQUIRED).SetParallelToolCalls(true)
+
+	resp, err := gpt.Generate()
+
+	if err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	reply := resp.Choices[0].Message
+
+	if len(reply.ToolCalls) != 2 || reply.ToolCalls[1].Id != "call_2" {
+		t.Fatalf("unexpected tool calls: %+v", reply.ToolCalls)
+	}
+
+	gpt.Messages = append(gpt.Messages, reply)
+
+	for _, call := range reply.ToolCalls {
+		w := new(Weather)
+		if err := json.Unmarshal([]byte(call.Function.Arguments), w); err != nil {
+			t.Fatalf("could not unmarshal: %v", err)
+		}
+		gpt.AddToolResult(call.Id, "Sunny in "+w.City)
+	}
+
+	gpt.RequireTool("get_weather")
+
+	if _, err := gpt.Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	first := requests[0]
+
+	if first["tool_choice"] != TOOL_CHOICE_REQUIRED || first["parallel_tool_calls"] != true {
+		t.Errorf("unexpected tool settings: %v %v", first["tool_choice"], first["parallel_tool_calls"])
+	}
+
+	tools := first["tools"].([]interface{})
+	fn := tools[0].(map[string]interface{})["function"].(map[string]interface{})
+
+	if fn["name"] != "get_weather" || fn["parameters"] == nil {
+		t.Errorf("unexpected tool definition: %+v", fn)
+	}
+
+	second := requests[1]
+	msgs := second["messages"].([]interface{})
+
+	if len(msgs) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(msgs))
+	}
+
+	if msgs[1].(map[string]interface{})["tool_calls"] == nil {
+		t.Errorf("assistant tool calls not sent back: %+v", msgs[1])
+	}
+
+	result := msgs[3].(map[string]interface{})
+
+	if result["role"] != ROLE_TOOL || result["tool_call_id"] != "call_2" || result["content"] != "Sunny in Rome" {
+		t.Errorf("unexpected tool result: %+v", result)
+	}
+
+	choice := second["tool_choice"].(map[string]interface{})
+
+	if choice["type"] != TOOL_TYPE_FUNCTION || choice["function"].(map[string]interface{})["name"] != "get_weather" {
+		t.Errorf("unexpected specific tool choice: %+v", choice)
+	}
+}
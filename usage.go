@@ -0,0 +1,219 @@
+package gogpt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+	A UsageAccountant adds up the GoGPTUsage of every response it sees and prices it with the
+	model registry. Attach one to a client with WithAccountant, or set Accountant on a query or
+	a chat's query. Queries derived for summarization inherit it, so hidden calls are counted too.
+
+	With a budget set, a request is refused with ErrBudgetExceeded when the money already spent plus
+	the worst case cost of the request (its prompt plus a full length reply) would exceed the budget.
+	A model without a registered price can't be held to a budget, so it is refused with ErrUnpriced.
+	The check and the recording are separate steps, so concurrent requests may overshoot slightly.
+*/
+
+const (
+	SUMMARY_TAG = "summarize"
+)
+
+var ErrBudgetExceeded = errors.New("budget exceeded")
+var ErrUnpriced = errors.New("model has no registered price")
+
+type UsageTotals struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+func (t *UsageTotals) add(usage GoGPTUsage, cost float64) {
+	t.Requests++
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.Cost += cost
+}
+
+type UsageAccountant struct {
+	mu      sync.Mutex
+	budget  float64
+	total   UsageTotals
+	byModel map[string]*UsageTotals
+	byUser  map[string]*UsageTotals
+	byTag   map[string]*UsageTotals
+}
+
+// NewUsageAccountant returns an accountant that refuses requests once budget dollars are spent. Zero means no limit.
+func NewUsageAccountant(budget float64) *UsageAccountant {
+	return &UsageAccountant{
+		budget:  budget,
+		byModel: map[string]*UsageTotals{},
+		byUser:  map[string]*UsageTotals{},
+		byTag:   map[string]*UsageTotals{},
+	}
+}
+
+// Cost prices usage with the model's registered per million token prices.
+func Cost(model string, usage GoGPTUsage) float64 {
+
+	info, ok := LookupModel(model)
+
+	if !ok {
+		return 0
+	}
+
+	return (float64(usage.PromptTokens)*info.PromptPrice + float64(usage.CompletionTokens)*info.CompletionPrice) / 1e6
+}
+
+// Record adds usage for a request and returns its cost.
+func (a *UsageAccountant) Record(model string, user string, tags []string, usage GoGPTUsage) float64 {
+
+	cost := Cost(model, usage)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total.add(usage, cost)
+
+	bucket(a.byModel, model).add(usage, cost)
+
+	if user != "" {
+		bucket(a.byUser, user).add(usage, cost)
+	}
+
+	for _, tag := range tags {
+		bucket(a.byTag, tag).add(usage, cost)
+	}
+
+	return cost
+}
+
+func bucket(m map[string]*UsageTotals, key string) *UsageTotals {
+
+	t, ok := m[key]
+
+	if !ok {
+		t = new(UsageTotals)
+		m[key] = t
+	}
+
+	return t
+}
+
+// priced reports whether the registry has a price for the model.
+func priced(model string) bool {
+
+	info, ok := LookupModel(model)
+
+	return ok && (info.PromptPrice > 0 || info.CompletionPrice > 0)
+}
+
+// Allow returns ErrBudgetExceeded if a request costing up to the given usage would break the budget,
+// or ErrUnpriced if there is a budget and the model has no price.
+func (a *UsageAccountant) Allow(model string, usage GoGPTUsage) error {
+
+	cost := Cost(model, usage)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.budget > 0 && !priced(model) {
+		return fmt.Errorf("%w: %s can't be held to a budget of $%.4f", ErrUnpriced, model, a.budget)
+	}
+
+	if a.budget > 0 && a.total.Cost+cost > a.budget {
+		return fmt.Errorf("%w: spent $%.4f of $%.4f and the request may cost $%.4f", ErrBudgetExceeded, a.total.Cost, a.budget, cost)
+	}
+
+	return nil
+}
+
+func (a *UsageAccountant) SetBudget(budget float64) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.budget = budget
+}
+
+// Remaining returns the unspent budget, or 0 if there is no budget.
+func (a *UsageAccountant) Remaining() float64 {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.budget <= 0 {
+		return 0
+	}
+
+	return a.budget - a.total.Cost
+}
+
+func (a *UsageAccountant) Total() UsageTotals {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.total
+}
+
+func (a *UsageAccountant) ByModel() map[string]UsageTotals {
+	return a.snapshot(a.byModel)
+}
+
+func (a *UsageAccountant) ByUser() map[string]UsageTotals {
+	return a.snapshot(a.byUser)
+}
+
+func (a *UsageAccountant) ByTag() map[string]UsageTotals {
+	return a.snapshot(a.byTag)
+}
+
+func (a *UsageAccountant) snapshot(m map[string]*UsageTotals) map[string]UsageTotals {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make(map[string]UsageTotals, len(m))
+
+	for k, v := range m {
+		out[k] = *v
+	}
+
+	return out
+}
+
+// allow checks the worst case cost of a query against its accountant's budget, given the prompt's count.
+func (g *GoGPTQuery) allow(prompt int, err error) error {
+
+	if g.Accountant == nil {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return g.Accountant.Allow(g.Model, GoGPTUsage{PromptTokens: prompt, CompletionTokens: replyTokens(g)})
+}
+
+func (g *GoGPTQuery) record(resp *GoGPTResponse) {
+
+	if g.Accountant == nil {
+		return
+	}
+
+	g.Accountant.Record(g.Model, g.User, g.Tags, resp.Usage)
+}
+
+// SetAccountant attaches an accountant to the chat, covering its summarization requests too.
+func (c *GoGPTChat) SetAccountant(a *UsageAccountant) *GoGPTChat {
+
+	c.Query.Accountant = a
+
+	return c
+}
@@ -0,0 +1,148 @@
+package gogpt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsageAccountant(t *testing.T) {
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"No."},"finish_reason":"stop"}],"usage":{"prompt_tokens":1000000,"completion_tokens":500000,"total_tokens":1500000}}`)
+	}))
+	defer server.Close()
+
+	acct := NewUsageAccountant(0)
+	client := NewClient("test-key", WithBaseURL(server.URL), WithAccountant(acct))
+
+	q := client.NewQuery()
+	q.Model = MODEL_4o_MINI
+	q.User = "alice"
+	q.Tags = []string{"search"}
+
+	if _, err := q.AddMessage(ROLE_USER, "", "Can pigs fly?").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	q = client.NewQuery()
+	q.Model = MODEL_4o
+	q.User = "bob"
+
+	if _, err := q.AddMessage(ROLE_USER, "", "Can pigs fly?").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	total := acct.Total()
+
+	// gpt-4o-mini: 0.15 + 0.30, gpt-4o: 2.50 + 5.00
+	if total.Requests != 2 || total.PromptTokens != 2000000 || fmt.Sprintf("%.2f", total.Cost) != "7.95" {
+		t.Errorf("unexpected totals: %+v", total)
+	}
+
+	if m := acct.ByModel()[MODEL_4o_MINI]; fmt.Sprintf("%.2f", m.Cost) != "0.45" {
+		t.Errorf("unexpected model totals: %+v", m)
+	}
+
+	if u := acct.ByUser()["bob"]; u.Requests != 1 || fmt.Sprintf("%.2f", u.Cost) != "7.50" {
+		t.Errorf("unexpected user totals: %+v", u)
+	}
+
+	if tag := acct.ByTag()["search"]; tag.Requests != 1 {
+		t.Errorf("unexpected tag totals: %+v", tag)
+	}
+
+	// The next gpt-4o request could cost more than what is left.
+	acct.SetBudget(8)
+
+	q = client.NewQuery()
+	q.Model = MODEL_4o
+	q.MaxTokens = 10000
+
+	_, err := q.AddMessage(ROLE_USER, "", "Can pigs fly?").Generate()
+
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got %v", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("a request over budget reached the server")
+	}
+}
+
+func TestUsageAccountantSummarization(t *testing.T) {
+
+	RegisterModel(ModelInfo{Name: "tiny-test-model", ContextWindow: 2000, MaxOutputTokens: 100, PromptPrice: 1, CompletionPrice: 1})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testReply)
+	}))
+	defer server.Close()
+
+	acct := NewUsageAccountant(0)
+
+	chat := NewGoGPTChat("test-key").SetAccountant(acct)
+	chat.Query.Endpoint = server.URL
+	chat.Query.Model = "tiny-test-model"
+	chat.Query.MaxTokens = 50
+	chat.AddMessage(ROLE_SYSTEM, "", "You are a farmer.")
+
+	for i := 0; i < 8; i++ {
+		chat.AddMessage(ROLE_USER, "", strings.Repeat("Tell me about pigs. ", 75))
+		if _, err := chat.Generate(); err != nil {
+			t.Fatalf("error generating: %v", err)
+		}
+	}
+
+	if acct.ByTag()[SUMMARY_TAG].Requests == 0 {
+		t.Errorf("summarization requests were not recorded: %+v", acct.ByTag())
+	}
+
+	if acct.Total().Requests <= 8 {
+		t.Errorf("expected more requests than turns, got %+v", acct.Total())
+	}
+}
+
+func TestUsageAccountantUnpriced(t *testing.T) {
+
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"No."},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	defer server.Close()
+
+	RegisterModel(ModelInfo{Name: "unpriced-test-model", ContextWindow: 2000, MaxOutputTokens: 1000, Encoding: ENCODING_CL100K})
+
+	acct := NewUsageAccountant(0)
+	client := NewClient("test-key", WithBaseURL(server.URL), WithAccountant(acct))
+
+	q := client.NewQuery()
+	q.Model = "unpriced-test-model"
+
+	// Without a budget there is nothing to enforce.
+	if _, err := q.AddMessage(ROLE_USER, "", "Can pigs fly?").Generate(); err != nil {
+		t.Fatalf("error generating: %v", err)
+	}
+
+	acct.SetBudget(1)
+
+	if _, err := q.Generate(); !errors.Is(err, ErrUnpriced) {
+		t.Errorf("expected ErrUnpriced, got %v", err)
+	}
+
+	if err := acct.Allow("no-such-model", GoGPTUsage{PromptTokens: 1}); !errors.Is(err, ErrUnpriced) {
+		t.Errorf("expected ErrUnpriced for an unknown model, got %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("a request that can't be priced reached the server")
+	}
+}
@@ -0,0 +1,172 @@
+package gogpt
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	Validate catches mistakes locally that the API would otherwise reject after a round trip.
+	It checks every rule and reports all of the problems at once in a *ValidationError.
+	Checks that depend on the model only apply to models in the registry.
+*/
+
+var functionName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid query: %s", strings.Join(e.Problems, "; "))
+}
+
+func (g *GoGPTQuery) Validate() error {
+
+	prompt := -1
+
+	if g.checksContext() {
+		if n, err := CountQueryTokens(g); err == nil {
+			prompt = n
+		}
+	}
+
+	return g.validate(prompt)
+}
+
+// checksContext reports whether validation checks that the prompt fits the model's context.
+func (g *GoGPTQuery) checksContext() bool {
+
+	_, ok := LookupModel(g.Model)
+
+	return ok && g.MaxTokens > 0 && len(g.Messages) > 0
+}
+
+// validate is Validate with the prompt already counted, or -1 if it couldn't be.
+func (g *GoGPTQuery) validate(prompt int) error {
+
+	var problems []string
+
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(g.Messages) == 0 {
+		add("no messages provided")
+	}
+
+	if g.Temperature < 0 || g.Temperature > 2 {
+		add("temperature %v must be between 0 and 2", g.Temperature)
+	}
+
+	if g.TopP < 0 || g.TopP > 1 {
+		add("top_p %v must be between 0 and 1", g.TopP)
+	}
+
+	if g.PresencePenalty < -2 || g.PresencePenalty > 2 {
+		add("presence_penalty %v must be between -2 and 2", g.PresencePenalty)
+	}
+
+	if g.N < 0 {
+		add("n %d must not be negative", g.N)
+	}
+
+	if g.MaxTokens < 0 {
+		add("max_tokens %d must not be negative", g.MaxTokens)
+	}
+
+	// Only check the prompt fits if we can count it; a missing tokenizer shouldn't block requests.
+	if prompt >= 0 && g.checksContext() && prompt+g.MaxTokens > MaxQueryTokens(g.Model) {
+		add("max_tokens %d plus a prompt of about %d tokens exceeds the %d token context of %s", g.MaxTokens, prompt, MaxQueryTokens(g.Model), g.Model)
+	}
+
+	problems = append(problems, g.modelProblems()...)
+
+	// Functions and tools share one namespace.
+	defined := map[string]bool{}
+
+	names := []string{}
+	for _, f := range g.Functions {
+		names = append(names, f.Name)
+	}
+	for _, t := range g.Tools {
+		if t.Type != TOOL_TYPE_FUNCTION {
+			add("tool %s has unsupported type %q", t.Function.Name, t.Type)
+		}
+		names = append(names, t.Function.Name)
+	}
+
+	for _, name := range names {
+		if !functionName.MatchString(name) {
+			add("function name %q must be 1-64 letters, digits, underscores or dashes", name)
+		}
+		if defined[name] {
+			add("function %s is defined more than once", name)
+		}
+		defined[name] = true
+	}
+
+	if choice, ok := g.ToolChoice.(GoGPTToolChoice); ok && !defined[choice.Function.Name] {
+		add("tool_choice names unknown function %s", choice.Function.Name)
+	}
+
+	switch g.FunctionCall {
+	case "", TOOL_CHOICE_AUTO, TOOL_CHOICE_NONE:
+	default:
+		if !defined[g.FunctionCall] {
+			add("function_call %q must be auto, none or a defined function", g.FunctionCall)
+		}
+	}
+
+	if choice, ok := g.ToolChoice.(string); ok {
+		switch choice {
+		case TOOL_CHOICE_AUTO, TOOL_CHOICE_NONE, TOOL_CHOICE_REQUIRED:
+		default:
+			add("tool_choice %q must be auto, none or required", choice)
+		}
+	}
+
+	for i, msg := range g.Messages {
+
+		switch msg.Role {
+		case ROLE_SYSTEM, ROLE_USER, ROLE_ASSISTANT:
+		case ROLE_FUNCTION:
+			if msg.Name == "" {
+				add("message %d has role function but no name", i)
+			}
+		case ROLE_TOOL:
+			if msg.ToolCallId == "" {
+				add("message %d has role tool but no tool_call_id", i)
+			}
+		default:
+			add("message %d has unknown role %q", i, msg.Role)
+		}
+
+		if msg.FunctionCall != nil && !defined[msg.FunctionCall.Name] {
+			add("message %d calls unknown function %s", i, msg.FunctionCall.Name)
+		}
+
+		for _, call := range msg.ToolCalls {
+			if !defined[call.Function.Name] {
+				add("message %d calls unknown function %s", i, call.Function.Name)
+			}
+		}
+	}
+
+	for key, bias := range g.LogitBias {
+		if id, err := strconv.Atoi(key); err != nil || id < 0 {
+			add("logit_bias key %q is not a token id", key)
+		}
+		if bias < -100 || bias > 100 {
+			add("logit_bias for %s is %v but must be between -100 and 100", key, bias)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
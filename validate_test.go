@@ -0,0 +1,102 @@
+package gogpt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+
+	q := NewGoGPTQuery("test-key")
+
+	var invalid *ValidationError
+
+	if err := q.Validate(); !errors.As(err, &invalid) || invalid.Problems[0] != "no messages provided" {
+		t.Fatalf("expected a validation error for no messages, got %v", err)
+	}
+
+	q.AddMessage(ROLE_USER, "", "What's the weather in Paris?")
+	q.AddTool("get_weather", "Get the weather for a city", testWeather{})
+
+	if err := q.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	q.Temperature = 3
+	q.TopP = -1
+	q.LogitBias = map[string]float32{"hello": 0, "50256": -200}
+	q.Messages = append(q.Messages,
+		GoGPTMessage{Role: ROLE_ASSISTANT, ToolCalls: []GoGPTToolCall{{Id: "call_1", Type: TOOL_TYPE_FUNCTION, Function: GoGPTFunctionCall{Name: "get_time"}}}},
+		GoGPTMessage{Role: ROLE_TOOL, Content: "12:00"},
+		GoGPTMessage{Role: "robot", Content: "beep"},
+	)
+	q.Tools = append(q.Tools, q.Tools[0])
+	q.RequireTool("get_news")
+
+	err := q.Validate()
+
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	for _, want := range []string{"temperature", "top_p", "logit_bias key", "logit_bias for 50256", "unknown function get_time", "tool_call_id", "unknown role", "more than once", "get_news"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected a problem mentioning %q in %v", want, err)
+		}
+	}
+
+	if _, err := q.request(); !errors.As(err, &invalid) {
+		t.Errorf("expected request to refuse an invalid query, got %v", err)
+	}
+}
+
+func TestValidateFunctionName(t *testing.T) {
+
+	q := NewGoGPTQuery("test-key")
+	q.AddMessage(ROLE_USER, "", "Hi")
+	q.Functions = []GoGPTFunction{{Name: "get weather"}}
+
+	if err := q.Validate(); err == nil || !strings.Contains(err.Error(), "get weather") {
+		t.Errorf("expected an invalid function name, got %v", err)
+	}
+
+	q.Functions[0].Name = "get_weather"
+	q.MaxTokens = 4000
+	q.Model = MODEL_4
+
+	q.Messages[0].Content = strings.Repeat("word ", 5000)
+
+	if err := q.Validate(); err == nil || !strings.Contains(err.Error(), "context") {
+		t.Errorf("expected the prompt and max_tokens to overflow the context, got %v", err)
+	}
+}
+
+func TestValidateFunctionCall(t *testing.T) {
+
+	q := NewGoGPTQuery("test-key")
+	q.AddMessage(ROLE_USER, "", "What's the weather in Paris?")
+	q.AddFunction("get_weather", "Get the weather for a city", testWeather{})
+
+	for _, call := range []string{"", "auto", "none", "get_weather"} {
+		q.FunctionCall = call
+		if err := q.Validate(); err != nil {
+			t.Errorf("function_call %q: unexpected error: %v", call, err)
+		}
+	}
+
+	q.FunctionCall = "get_time"
+
+	if err := q.Validate(); err == nil || !strings.Contains(err.Error(), "function_call \"get_time\"") {
+		t.Errorf("expected an undefined function_call, got %v", err)
+	}
+
+	q.FunctionCall = ""
+	q.Model = MODEL_4
+	q.MaxTokens = 4000
+	q.Messages[0].Content = strings.Repeat("word ", 5000)
+
+	if _, err := q.request(); err == nil || !strings.Contains(err.Error(), "context") {
+		t.Errorf("expected request to catch the overflow with its own count, got %v", err)
+	}
+}
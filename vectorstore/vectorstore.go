@@ -0,0 +1,405 @@
+/*
+Package vectorstore is an in-memory vector index for semantic search over embeddings, small enough
+to embed in a service instead of running a vector database.
+
+Records are added, upserted and deleted by ID, carry string metadata that searches can filter on,
+and are ranked by cosine similarity, dot product or euclidean distance. Results always rank the
+best match first: for METRIC_EUCLIDEAN the score is the negated distance. An index can be written
+to and read from a JSON or gob snapshot.
+
+	emb, err := client.NewEmbeddingsQuery().GenerateWithContext(ctx)
+	index := vectorstore.New(vectorstore.METRIC_COSINE)
+	err = index.AddEmbeddings(emb.Data, ids, nil)
+
+	results, err := index.Search(query, 5, vectorstore.Match(map[string]string{"lang": "en"}))
+
+An Index is safe for concurrent use.
+*/
+package vectorstore
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dratner/gogpt"
+)
+
+const (
+	METRIC_COSINE    = "cosine"
+	METRIC_DOT       = "dot"
+	METRIC_EUCLIDEAN = "euclidean"
+	SNAPSHOT_VERSION = 1
+)
+
+type Record struct {
+	Id       string            `json:"id"`
+	Vector   []float64         `json:"vector"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type Result struct {
+	Record
+	Score float64 `json:"score"`
+}
+
+// A Filter decides whether a record may appear in search results.
+type Filter func(Record) bool
+
+// Match returns a filter accepting records whose metadata has every given key and value.
+func Match(metadata map[string]string) Filter {
+	return func(r Record) bool {
+		for k, v := range metadata {
+			if r.Metadata[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+type entry struct {
+	record Record
+	norm   float64
+}
+
+type Index struct {
+	mu      sync.RWMutex
+	metric  string
+	dims    int
+	entries map[string]*entry
+}
+
+// New returns an empty index ranking by the given metric, or cosine similarity if it is empty.
+func New(metric string) *Index {
+
+	if metric == "" {
+		metric = METRIC_COSINE
+	}
+
+	return &Index{metric: metric, entries: map[string]*entry{}}
+}
+
+func (x *Index) Metric() string {
+	return x.metric
+}
+
+// Dimensions returns the length of the vectors in the index, or 0 if it has never held any.
+func (x *Index) Dimensions() int {
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return x.dims
+}
+
+func (x *Index) Len() int {
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return len(x.entries)
+}
+
+// check validates records before any are stored, so a bad batch changes nothing.
+func (x *Index) check(records []Record, replace bool) error {
+
+	dims := x.dims
+	seen := map[string]bool{}
+
+	for _, r := range records {
+
+		if r.Id == "" {
+			return fmt.Errorf("record id is required")
+		}
+
+		if len(r.Vector) == 0 {
+			return fmt.Errorf("record %s has no vector", r.Id)
+		}
+
+		if dims == 0 {
+			dims = len(r.Vector)
+		}
+
+		if len(r.Vector) != dims {
+			return fmt.Errorf("record %s has %d dimensions, expected %d", r.Id, len(r.Vector), dims)
+		}
+
+		if _, ok := x.entries[r.Id]; (ok && !replace) || seen[r.Id] {
+			return fmt.Errorf("record %s already exists", r.Id)
+		}
+
+		seen[r.Id] = true
+	}
+
+	return nil
+}
+
+func (x *Index) store(records []Record) {
+
+	for _, r := range records {
+
+		r.Vector = append([]float64{}, r.Vector...)
+
+		if r.Metadata != nil {
+			metadata := make(map[string]string, len(r.Metadata))
+			for k, v := range r.Metadata {
+				metadata[k] = v
+			}
+			r.Metadata = metadata
+		}
+
+		x.entries[r.Id] = &entry{record: r, norm: norm(r.Vector)}
+		x.dims = len(r.Vector)
+	}
+}
+
+// Add inserts new records. It fails without changing the index if any ID is already present.
+func (x *Index) Add(records ...Record) error {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := x.check(records, false); err != nil {
+		return err
+	}
+
+	x.store(records)
+
+	return nil
+}
+
+// Upsert inserts records, replacing any with the same ID.
+func (x *Index) Upsert(records ...Record) error {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if err := x.check(records, true); err != nil {
+		return err
+	}
+
+	x.store(records)
+
+	return nil
+}
+
+// Delete removes records by ID and returns how many were present.
+func (x *Index) Delete(ids ...string) int {
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	n := 0
+
+	for _, id := range ids {
+		if _, ok := x.entries[id]; ok {
+			delete(x.entries, id)
+			n++
+		}
+	}
+
+	return n
+}
+
+func (x *Index) Get(id string) (Record, bool) {
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	e, ok := x.entries[id]
+
+	if !ok {
+		return Record{}, false
+	}
+
+	return e.record, true
+}
+
+/*
+	AddEmbeddings upserts the vectors of an embeddings response. Each EmbeddingData's Index picks its
+	ID from ids and, if given, its metadata, so the Data of a batched GoGPTEmbeddingsQuery can be
+	passed straight in with the IDs of the inputs.
+*/
+
+func (x *Index) AddEmbeddings(data []gogpt.EmbeddingData, ids []string, metadata []map[string]string) error {
+
+	records := make([]Record, 0, len(data))
+
+	for _, d := range data {
+
+		if d.Index < 0 || d.Index >= len(ids) {
+			return fmt.Errorf("no id for embedding %d", d.Index)
+		}
+
+		r := Record{Id: ids[d.Index], Vector: d.Embedding}
+
+		if d.Index < len(metadata) {
+			r.Metadata = metadata[d.Index]
+		}
+
+		records = append(records, r)
+	}
+
+	return x.Upsert(records...)
+}
+
+// Search returns the k records most similar to query that pass filter, best first. A nil filter accepts everything.
+func (x *Index) Search(query []float64, k int, filter Filter) ([]Result, error) {
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if x.dims > 0 && len(query) != x.dims {
+		return nil, fmt.Errorf("query has %d dimensions, expected %d", len(query), x.dims)
+	}
+
+	score, err := x.scorer(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+
+	for _, e := range x.entries {
+		if filter == nil || filter(e.record) {
+			results = append(results, Result{Record: e.record, Score: score(e)})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Id < results[j].Id
+	})
+
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+
+	return results, nil
+}
+
+func (x *Index) scorer(query []float64) (func(*entry) float64, error) {
+
+	switch x.metric {
+	case METRIC_COSINE:
+		qn := norm(query)
+		return func(e *entry) float64 {
+			if qn == 0 || e.norm == 0 {
+				return 0
+			}
+			return dot(query, e.record.Vector) / (qn * e.norm)
+		}, nil
+	case METRIC_DOT:
+		return func(e *entry) float64 {
+			return dot(query, e.record.Vector)
+		}, nil
+	case METRIC_EUCLIDEAN:
+		return func(e *entry) float64 {
+			sum := 0.0
+			for i := range query {
+				d := query[i] - e.record.Vector[i]
+				sum += d * d
+			}
+			return -math.Sqrt(sum)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown metric %q", x.metric)
+}
+
+func dot(a []float64, b []float64) float64 {
+
+	sum := 0.0
+
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+
+	return sum
+}
+
+func norm(v []float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
+
+/*
+	Snapshots
+*/
+
+type snapshot struct {
+	Version int      `json:"version"`
+	Metric  string   `json:"metric"`
+	Records []Record `json:"records"`
+}
+
+func (x *Index) snapshot() snapshot {
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	s := snapshot{Version: SNAPSHOT_VERSION, Metric: x.metric, Records: make([]Record, 0, len(x.entries))}
+
+	for _, e := range x.entries {
+		s.Records = append(s.Records, e.record)
+	}
+
+	sort.Slice(s.Records, func(i, j int) bool {
+		return s.Records[i].Id < s.Records[j].Id
+	})
+
+	return s
+}
+
+func restore(s snapshot) (*Index, error) {
+
+	if s.Version < 1 || s.Version > SNAPSHOT_VERSION {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	x := New(s.Metric)
+
+	if err := x.Add(s.Records...); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (x *Index) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(x.snapshot())
+}
+
+func ReadJSON(r io.Reader) (*Index, error) {
+
+	var s snapshot
+
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	return restore(s)
+}
+
+// WriteGob writes a compact binary snapshot.
+func (x *Index) WriteGob(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(x.snapshot())
+}
+
+func ReadGob(r io.Reader) (*Index, error) {
+
+	var s snapshot
+
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	return restore(s)
+}
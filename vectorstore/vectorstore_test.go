@@ -0,0 +1,172 @@
+package vectorstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/dratner/gogpt"
+)
+
+func testIndex(t *testing.T, metric string) *Index {
+
+	x := New(metric)
+
+	err := x.Add(
+		Record{Id: "pigs", Vector: []float64{1, 0, 0}, Metadata: map[string]string{"animal": "pig", "lang": "en"}},
+		Record{Id: "cows", Vector: []float64{0, 1, 0}, Metadata: map[string]string{"animal": "cow", "lang": "en"}},
+		Record{Id: "porcs", Vector: []float64{0.9, 0.1, 0}, Metadata: map[string]string{"animal": "pig", "lang": "fr"}},
+		Record{Id: "big-pigs", Vector: []float64{3, 0, 0}, Metadata: map[string]string{"animal": "pig", "lang": "en"}},
+	)
+
+	if err != nil {
+		t.Fatalf("error adding: %v", err)
+	}
+
+	return x
+}
+
+func ids(results []Result) []string {
+
+	var out []string
+
+	for _, r := range results {
+		out = append(out, r.Id)
+	}
+
+	return out
+}
+
+func TestSearch(t *testing.T) {
+
+	query := []float64{1, 0, 0}
+
+	tests := []struct {
+		metric string
+		want   []string
+	}{
+		{METRIC_COSINE, []string{"big-pigs", "pigs", "porcs"}},
+		{METRIC_DOT, []string{"big-pigs", "pigs", "porcs"}},
+		{METRIC_EUCLIDEAN, []string{"pigs", "porcs", "cows"}},
+	}
+
+	for _, test := range tests {
+
+		results, err := testIndex(t, test.metric).Search(query, 3, nil)
+
+		if err != nil {
+			t.Fatalf("error searching: %v", err)
+		}
+
+		if got := ids(results); len(got) != 3 || got[0] != test.want[0] || got[1] != test.want[1] || got[2] != test.want[2] {
+			t.Errorf("%s: expected %v, got %v", test.metric, test.want, got)
+		}
+	}
+
+	results, err := testIndex(t, METRIC_COSINE).Search(query, 10, Match(map[string]string{"lang": "fr"}))
+
+	if err != nil || len(results) != 1 || results[0].Id != "porcs" {
+		t.Errorf("unexpected filtered results: %+v %v", results, err)
+	}
+
+	if _, err := testIndex(t, METRIC_COSINE).Search([]float64{1, 0}, 1, nil); err == nil {
+		t.Errorf("expected an error for a query with the wrong dimensions")
+	}
+}
+
+func TestAddUpsertDelete(t *testing.T) {
+
+	x := testIndex(t, METRIC_COSINE)
+
+	if err := x.Add(Record{Id: "pigs", Vector: []float64{0, 0, 1}}); err == nil {
+		t.Errorf("expected an error adding an existing id")
+	}
+
+	if err := x.Upsert(Record{Id: "goats", Vector: []float64{0, 0, 1}}, Record{Id: "bad", Vector: []float64{1}}); err == nil || x.Len() != 4 {
+		t.Errorf("expected a bad batch to change nothing, got %v with %d records", err, x.Len())
+	}
+
+	if err := x.Upsert(Record{Id: "pigs", Vector: []float64{0, 0, 1}}); err != nil {
+		t.Fatalf("error upserting: %v", err)
+	}
+
+	if r, ok := x.Get("pigs"); !ok || r.Vector[2] != 1 || r.Metadata != nil {
+		t.Errorf("record was not replaced: %+v", r)
+	}
+
+	if n := x.Delete("pigs", "missing"); n != 1 || x.Len() != 3 {
+		t.Errorf("unexpected delete count %d with %d records", n, x.Len())
+	}
+}
+
+func TestAddEmbeddings(t *testing.T) {
+
+	var emb gogpt.GoGPTEmbeddings
+
+	// Data arrives in any order; Index says which input it belongs to.
+	data := `{"object":"list","model":"text-embedding-3-small","data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`
+
+	if err := json.Unmarshal([]byte(data), &emb); err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+
+	x := New("")
+
+	if err := x.AddEmbeddings(emb.Data, []string{"doc-a", "doc-b"}, []map[string]string{{"title": "A"}}); err != nil {
+		t.Fatalf("error adding embeddings: %v", err)
+	}
+
+	if r, ok := x.Get("doc-b"); !ok || r.Vector[1] != 1 || r.Metadata != nil {
+		t.Errorf("unexpected record: %+v", r)
+	}
+
+	if r, ok := x.Get("doc-a"); !ok || r.Metadata["title"] != "A" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+
+	if err := x.AddEmbeddings(emb.Data, []string{"doc-a"}, nil); err == nil {
+		t.Errorf("expected an error for missing ids")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+
+	x := testIndex(t, METRIC_EUCLIDEAN)
+
+	var j, g bytes.Buffer
+
+	if err := x.WriteJSON(&j); err != nil {
+		t.Fatalf("error writing json: %v", err)
+	}
+
+	if err := x.WriteGob(&g); err != nil {
+		t.Fatalf("error writing gob: %v", err)
+	}
+
+	fromJSON, err := ReadJSON(&j)
+
+	if err != nil {
+		t.Fatalf("error reading json: %v", err)
+	}
+
+	fromGob, err := ReadGob(&g)
+
+	if err != nil {
+		t.Fatalf("error reading gob: %v", err)
+	}
+
+	for _, y := range []*Index{fromJSON, fromGob} {
+
+		if y.Len() != 4 || y.Metric() != METRIC_EUCLIDEAN || y.Dimensions() != 3 {
+			t.Errorf("unexpected index: %d records, %s, %d dimensions", y.Len(), y.Metric(), y.Dimensions())
+		}
+
+		if r, ok := y.Get("porcs"); !ok || r.Metadata["lang"] != "fr" || r.Vector[1] != 0.1 {
+			t.Errorf("unexpected record: %+v", r)
+		}
+	}
+
+	if _, err := ReadJSON(bytes.NewBufferString(`{"version":99,"metric":"cosine"}`)); err == nil {
+		t.Errorf("expected an error for an unknown snapshot version")
+	}
+}